@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/storage/postgres"
+)
+
+const (
+	_EXIT_OK    = 0
+	_EXIT_ERROR = 1
+	// invalid flags, or arguments
+	_EXIT_USAGE = 2
+
+	_DEFAULT_CONFIG_PATH = "./config/conf.json"
+)
+
+// a command either has subcommands, or runs. every command that runs has a -config flag.
+type command struct {
+	name    string
+	summary string
+	// the arguments after the flags, for the help. like "<version>".
+	args        string
+	subcommands []*command
+	// defines the flags of the command, and returns what it does with them
+	setup func(fs *flag.FlagSet) action
+	// the config sections the command uses, like config.SECTION_RELATIONAL_DB. only these are
+	// validated. nil validates every section.
+	sections []string
+}
+
+// sections of the commands that only connect to the database
+var dbSections = []string{config.SECTION_RELATIONAL_DB}
+
+type action struct {
+	// checks the flags, and the arguments before the config is loaded. an error prints the help
+	// of the command, and exits with _EXIT_USAGE. nil accepts no arguments.
+	check func(args []string) error
+	run   func(conf *config.AppConfig, args []string) error
+}
+
+var root = &command{
+	name:    "qa",
+	summary: "the question, and answer service",
+	subcommands: []*command{
+		serveCommand,
+		migrateCommand,
+		userCommand,
+		tokenCommand,
+		configCommand,
+	},
+}
+
+// runs the command in args, which don't include the program name. returns the exit code.
+func Execute(args []string) int {
+	return root.execute(root.name, args, os.Stdout, os.Stderr)
+}
+
+func (c *command) execute(path string, args []string, stdout, stderr io.Writer) int {
+	if len(c.subcommands) > 0 {
+		if len(args) == 0 {
+			c.printHelp(path, stderr)
+			return _EXIT_USAGE
+		}
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			c.printHelp(path, stdout)
+			return _EXIT_OK
+		}
+		for _, sub := range c.subcommands {
+			if sub.name == args[0] {
+				return sub.execute(path+" "+sub.name, args[1:], stdout, stderr)
+			}
+		}
+		fmt.Fprintf(stderr, "unknown command '%s'\n\n", args[0])
+		c.printHelp(path, stderr)
+		return _EXIT_USAGE
+	}
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configPath := fs.String("config", _DEFAULT_CONFIG_PATH, "path of the config file (.json, .yaml, .yml, or .toml). empty for none")
+	sets := &setFlag{}
+	fs.Var(sets, "set", "override a config field, like 'httpServer.port=9000'. can be repeated")
+	act := c.setup(fs)
+	if act.check == nil {
+		act.check = noArgs
+	}
+	fs.Usage = func() {
+		c.printUsage(path, fs, fs.Output())
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return _EXIT_OK
+		}
+		return _EXIT_USAGE
+	}
+	if err := act.check(fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "%s\n\n", err.Error())
+		c.printUsage(path, fs, stderr)
+		return _EXIT_USAGE
+	}
+	// flags of a command, like 'serve -port', come before -set
+	overrides := []string{}
+	fs.Visit(func(f *flag.Flag) {
+		if cf, ok := f.Value.(*configFlag); ok {
+			overrides = append(overrides, cf.path+"="+cf.value)
+		}
+	})
+	conf, err := config.Load(*configPath, append(overrides, *sets...), c.sections...)
+	if err != nil {
+		log.Printf("[ERROR] %s: %s\n", path, err.Error())
+		return _EXIT_ERROR
+	}
+	if err := act.run(conf, fs.Args()); err != nil {
+		log.Printf("[ERROR] %s: %s\n", path, err.Error())
+		return _EXIT_ERROR
+	}
+	return _EXIT_OK
+}
+
+func (c *command) printHelp(path string, w io.Writer) {
+	fmt.Fprintf(w, "%s - %s\n\nusage: %s <command>\n\ncommands:\n", path, c.summary, path)
+	width := 0
+	for _, sub := range c.subcommands {
+		if len(sub.name) > width {
+			width = len(sub.name)
+		}
+	}
+	for _, sub := range c.subcommands {
+		fmt.Fprintf(w, "  %s%s  %s\n", sub.name, strings.Repeat(" ", width-len(sub.name)), sub.summary)
+	}
+	fmt.Fprintf(w, "\nrun '%s <command> -h' for the help of a command\n", path)
+}
+
+func (c *command) printUsage(path string, fs *flag.FlagSet, w io.Writer) {
+	usage := path + " [flags]"
+	if c.args != "" {
+		usage += " " + c.args
+	}
+	fmt.Fprintf(w, "%s - %s\n\nusage: %s\n\nflags:\n", path, c.summary, usage)
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// a flag that overrides a config field
+type configFlag struct {
+	path   string
+	value  string
+	isBool bool
+}
+
+func (c *configFlag) String() string {
+	return c.value
+}
+
+func (c *configFlag) Set(s string) error {
+	c.value = s
+	return nil
+}
+
+func (c *configFlag) IsBoolFlag() bool {
+	return c.isBool
+}
+
+// -set path=value
+type setFlag []string
+
+func (s *setFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *setFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func noArgs(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+	}
+	return nil
+}
+
+func connectDB(conf *config.AppConfig) (*postgres.Postgres, error) {
+	pg, err := postgres.New(&conf.RelationalDB)
+	if err != nil {
+		return nil, err
+	}
+	if err := pg.Connect(); err != nil {
+		return nil, err
+	}
+	return pg, nil
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+)
+
+var configCommand = &command{
+	name:    "config",
+	summary: "check the config",
+	subcommands: []*command{
+		{
+			name:    "validate",
+			summary: "parse, and validate the config, and load the JWT keys",
+			setup: func(fs *flag.FlagSet) action {
+				return action{run: func(conf *config.AppConfig, args []string) error {
+					// the config is parsed, and validated before every command. the keys are
+					// loaded when the server starts.
+					if _, err := jwtauth.NewTokenRepo(&conf.Auth.Jwt); err != nil {
+						return err
+					}
+					fmt.Println("config is valid")
+					return nil
+				}}
+			},
+		},
+	},
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// SIGHUP starts a new process of the same binary, with the same arguments, and gives it the
+// listening socket. the old process drains only after the new one is ready, so there is always a
+// process accepting. the new process reads the config again, but keeps the port of the socket.
+//
+// the new process isn't a child of the supervisor, so the supervisor has to track the process by
+// something other than its pid, like the port.
+
+const (
+	// set for the new process. it has the listener as fd 3, and the write end of a pipe as fd 4. it
+	// writes a byte to the pipe when it's ready.
+	_HANDOFF_ENV = "QA_HANDOFF"
+	// the old process gives up on a new process that isn't ready by then, and keeps serving
+	_HANDOFF_TIMEOUT = time.Minute
+)
+
+// the listener handed off by the old process, and the pipe to tell it this one is ready, or a
+// new listener, and nil
+func listen(port uint) (net.Listener, *os.File, error) {
+	if os.Getenv(_HANDOFF_ENV) != "1" {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		return ln, nil, err
+	}
+	// not for the processes this one starts
+	os.Unsetenv(_HANDOFF_ENV)
+	f := os.NewFile(3, "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("handed off listener: %w", err)
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok && uint(addr.Port) != port {
+		log.Printf("[WARNING] kept port %d of the handed off listener, instead of %d. restart to change the port\n", addr.Port, port)
+	}
+	return ln, os.NewFile(4, "ready"), nil
+}
+
+func signalReady(ready *os.File) error {
+	defer ready.Close()
+	_, err := ready.Write([]byte{1})
+	return err
+}
+
+// returns after the new process is ready
+func handOff(ln net.Listener) error {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("can't hand off a %T", ln)
+	}
+	f, err := tl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	c := exec.Command(exe, os.Args[1:]...)
+	c.Stdout, c.Stderr = os.Stdout, os.Stderr
+	c.Env = append(os.Environ(), _HANDOFF_ENV+"=1")
+	c.ExtraFiles = []*os.File{f, w}
+	err = c.Start()
+	// only the new process has the write end now, so a read gets EOF if it exits
+	w.Close()
+	if err != nil {
+		return err
+	}
+	r.SetReadDeadline(time.Now().Add(_HANDOFF_TIMEOUT))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return fmt.Errorf("the new process wasn't ready: %w", err)
+	}
+	return nil
+}
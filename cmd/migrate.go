@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/storage/migrations"
+)
+
+var migrateCommand = &command{
+	name:    "migrate",
+	summary: "apply, or revert database migrations",
+	subcommands: []*command{
+		{
+			name:     "up",
+			sections: dbSections,
+			summary:  "apply every pending migration",
+			setup: func(fs *flag.FlagSet) action {
+				return action{run: func(conf *config.AppConfig, args []string) error {
+					return runMigrations(conf, (*migrations.Migrator).Up)
+				}}
+			},
+		},
+		{
+			name:     "down",
+			sections: dbSections,
+			summary:  "revert the latest applied migration",
+			setup: func(fs *flag.FlagSet) action {
+				return action{run: func(conf *config.AppConfig, args []string) error {
+					return runMigrations(conf, (*migrations.Migrator).Down)
+				}}
+			},
+		},
+		{
+			name:     "to",
+			sections: dbSections,
+			summary:  "migrate up, or down to the version. 0 reverts everything",
+			args:     "<version>",
+			setup: func(fs *flag.FlagSet) action {
+				var version int64
+				return action{
+					check: func(args []string) error {
+						if len(args) != 1 {
+							return fmt.Errorf("expected one version")
+						}
+						v, err := strconv.ParseInt(args[0], 10, 64)
+						if err != nil || v < 0 {
+							return fmt.Errorf("invalid version '%s'", args[0])
+						}
+						version = v
+						return nil
+					},
+					run: func(conf *config.AppConfig, args []string) error {
+						return runMigrations(conf, func(m *migrations.Migrator) ([]migrations.Migration, error) {
+							return m.To(version)
+						})
+					},
+				}
+			},
+		},
+		{
+			name:     "status",
+			sections: dbSections,
+			summary:  "list migrations, and when they were applied",
+			setup: func(fs *flag.FlagSet) action {
+				return action{run: func(conf *config.AppConfig, args []string) error {
+					return withMigrator(conf, printMigrationStatus)
+				}}
+			},
+		},
+	},
+}
+
+// runs fn, and lists the migrations that ran
+func runMigrations(conf *config.AppConfig, fn func(m *migrations.Migrator) ([]migrations.Migration, error)) error {
+	return withMigrator(conf, func(m *migrations.Migrator) error {
+		ran, err := fn(m)
+		// the migrations that ran before a failure are committed, so they're listed either way
+		for _, mig := range ran {
+			fmt.Printf("%d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(ran) == 0 {
+			fmt.Println("nothing to migrate")
+		}
+		return nil
+	})
+}
+
+func withMigrator(conf *config.AppConfig, fn func(m *migrations.Migrator) error) error {
+	pg, err := connectDB(conf)
+	if err != nil {
+		return err
+	}
+	defer pg.Db.Close()
+	m, err := migrations.New(pg.Db)
+	if err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+func printMigrationStatus(m *migrations.Migrator) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = "applied at " + s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%d_%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return nil
+}
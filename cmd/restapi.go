@@ -1,10 +1,16 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"strings"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/betelgeuse-7/qa/config"
 	"github.com/betelgeuse-7/qa/httphandlers"
@@ -12,30 +18,22 @@ import (
 	_ "github.com/lib/pq"
 )
 
-const (
-	_ROOT_PRIVILEGED_PORTS_END = uint(1025)
-	_PORTS_END                 = uint(65535)
-	_USAGE                     = "\t*---------------------------------------------------------------------*\n" +
-		"\t\t-tls, --tls\t\t\t\tUse TLS\n" +
-		"\t\t-port, --port, -port=, --port=\t\tPort number\n" +
-		"\t\t-cert, --cert, -cert=, --cert=\t\tSSL certificate path\n" +
-		"\t\t-key, --key, -key=, --key=\t\tSSL key path\n" +
-		"\t\t-help, --help\t\t\t\tGet help\n" +
-		"\t*---------------------------------------------------------------------*\n"
-)
+var serveCommand = &command{
+	name:    "serve",
+	summary: "run the REST API. SIGINT, or SIGTERM drains it, and SIGHUP restarts it without dropping connections",
+	setup: func(fs *flag.FlagSet) action {
+		fs.Var(&configFlag{path: "httpServer.useTLS", isBool: true}, "tls", "use TLS. overrides httpServer.useTLS")
+		fs.Var(&configFlag{path: "httpServer.port"}, "port", "port to listen on, in [1025,65535]. overrides httpServer.port")
+		fs.Var(&configFlag{path: "httpServer.certFile"}, "cert", "SSL certificate path. overrides httpServer.certFile")
+		fs.Var(&configFlag{path: "httpServer.keyFile"}, "key", "SSL key path. overrides httpServer.keyFile")
+		return action{run: func(conf *config.AppConfig, args []string) error {
+			return RunQARestAPI(conf)
+		}}
+	},
+}
 
-func RunQARestAPI(conf *config.AppConfig) {
-	flags, err := __start()
-	if err != nil {
-		log.Printf("[ERROR] cmd/restapi.go: couldn't set REST routes: %s", err.Error())
-		return
-	}
-	if flags.help {
-		fmt.Print(_USAGE)
-		return
-	}
-	conf.HttpServer.UseTLS = flags.useTLS
-	conf.HttpServer.Port = flags.port
+// returns after the server is drained
+func RunQARestAPI(conf *config.AppConfig) error {
 	if !(conf.HttpServer.DevMode) {
 		// in release/prod mode
 		gin.SetMode(gin.ReleaseMode)
@@ -49,50 +47,80 @@ func RunQARestAPI(conf *config.AppConfig) {
 		// So, this doesn't actually change anything.
 		r.UseH2C = true
 	}
-	e := httphandlers.NewEngine(r)
-	if err := e.SetRESTRoutes(&conf.RelationalDB, &conf.Auth.Jwt, conf.HttpServer.UseTLS); err != nil {
-		log.Printf("[ERROR] cmd/restapi.go: couldn't set REST routes: %s\n", err.Error())
-		return
+	ln, ready, err := listen(conf.HttpServer.Port)
+	if err != nil {
+		return err
 	}
-	if conf.HttpServer.UseTLS {
-		log.Fatalln(r.RunTLS(conf.HttpServer.Port, flags.sslCert, flags.sslKey))
+	defer ln.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	e := httphandlers.NewEngine(r)
+	// stops the background jobs, and closes the database after the server is drained
+	defer func() {
+		cancel()
+		if err := e.Close(); err != nil {
+			log.Printf("[ERROR] cmd/restapi.go: close: %s\n", err.Error())
+		}
+	}()
+	if err := e.SetRESTRoutes(ctx, &conf.RelationalDB, &conf.Auth.Jwt, &conf.Privileges, &conf.Retention, &conf.HttpServer); err != nil {
+		return fmt.Errorf("couldn't set REST routes: %w", err)
 	}
-	log.Fatalln(r.Run(conf.HttpServer.Port))
-}
-
-type flags struct {
-	useTLS, help          bool
-	port, sslCert, sslKey string
-}
-
-func __start() (flags, error) {
-	var res flags
-	help := flag.Bool("help", false, "help")
-	useTLS := flag.Bool("tls", false, "use tls")
-	portUint := flag.Uint("port", 8000, "port to listen on")
-	certLocation := flag.String("cert", "", "ssl certificate location")
-	keyLocation := flag.String("key", "", "ssl key location")
-	flag.Parse()
-	if !(*portUint >= _ROOT_PRIVILEGED_PORTS_END && *portUint <= _PORTS_END) {
-		return res, fmt.Errorf("invalid port (out of range [1025,65535]): '%d'", *portUint)
+	srv := &http.Server{
+		Handler:      r.Handler(),
+		ReadTimeout:  seconds(conf.HttpServer.ReadTimeoutSeconds),
+		WriteTimeout: seconds(conf.HttpServer.WriteTimeoutSeconds),
+		IdleTimeout:  seconds(conf.HttpServer.IdleTimeoutSeconds),
 	}
-	port := fmt.Sprintf(":%d", *portUint)
-	res.useTLS = *useTLS
-	res.port = port
-	res.sslCert = *certLocation
-	res.sslKey = *keyLocation
-	res.help = *help
-	if res.useTLS {
-		var errStr strings.Builder
-		if len(res.sslCert) == 0 {
-			errStr.WriteString("\n\t- Missing SSL certificate path")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	serveErr := make(chan error, 1)
+	go func() {
+		if conf.HttpServer.UseTLS {
+			serveErr <- srv.ServeTLS(ln, conf.HttpServer.CertFile, conf.HttpServer.KeyFile)
+			return
 		}
-		if len(res.sslKey) == 0 {
-			errStr.WriteString("\n\t- Missing SSL key path\n")
+		serveErr <- srv.Serve(ln)
+	}()
+	if ready != nil {
+		if err := signalReady(ready); err != nil {
+			log.Printf("[ERROR] cmd/restapi.go: tell the old process that this one is ready: %s\n", err.Error())
+		}
+	}
+	log.Printf("[INFO] listening on %s\n", ln.Addr().String())
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := handOff(ln); err != nil {
+					log.Printf("[ERROR] cmd/restapi.go: restart: %s. still serving\n", err.Error())
+					continue
+				}
+				log.Println("[INFO] handed off the listener to the new process. draining")
+			} else {
+				log.Printf("[INFO] %s. draining\n", sig.String())
+			}
+			return shutdown(srv, seconds(conf.HttpServer.ShutdownTimeoutSeconds))
 		}
-		if len(errStr.String()) != 0 {
-			return res, fmt.Errorf("%s", errStr.String())
+	}
+}
+
+// stops accepting, and waits for in-flight requests until the timeout. then closes the
+// connections that are left.
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("requests didn't finish in %s. closed their connections", timeout)
 		}
+		return err
 	}
-	return res, nil
+	return nil
+}
+
+func seconds(n int64) time.Duration {
+	return time.Duration(n) * time.Second
 }
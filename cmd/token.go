@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/betelgeuse-7/qa/storage/models"
+)
+
+var tokenCommand = &command{
+	name:    "token",
+	summary: "issue tokens",
+	subcommands: []*command{
+		{
+			name:     "issue",
+			sections: []string{config.SECTION_RELATIONAL_DB, config.SECTION_AUTH},
+			summary:  "print an access token of a user, with their current role",
+			setup: func(fs *flag.FlagSet) action {
+				userId := fs.Int64("user", 0, "id of the user")
+				return action{
+					check: func(args []string) error {
+						if err := noArgs(args); err != nil {
+							return err
+						}
+						if *userId <= 0 {
+							return fmt.Errorf("missing user id (-user)")
+						}
+						return nil
+					},
+					run: func(conf *config.AppConfig, args []string) error {
+						return issueToken(conf, *userId)
+					},
+				}
+			},
+		},
+	},
+}
+
+func issueToken(conf *config.AppConfig, userId int64) error {
+	tr, err := jwtauth.NewTokenRepo(&conf.Auth.Jwt)
+	if err != nil {
+		return err
+	}
+	pg, err := connectDB(conf)
+	if err != nil {
+		return err
+	}
+	defer pg.Db.Close()
+	role, err := models.NewUserRepo(pg.Db, sqlbuild.New()).GetUserRole(userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no such user")
+		}
+		return err
+	}
+	token, err := jwtauth.NewAccessToken(tr, userId, role)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/betelgeuse-7/qa/storage/postgres"
+	"github.com/lib/pq"
+)
+
+var userCommand = &command{
+	name:    "user",
+	summary: "manage users",
+	subcommands: []*command{
+		{
+			name:     "create-admin",
+			sections: dbSections,
+			summary:  "register an admin. the password is read from the first line of stdin",
+			setup: func(fs *flag.FlagSet) action {
+				urp := &models.UserRegisterPayload{}
+				fs.StringVar(&urp.Username, "username", "", "username")
+				fs.StringVar(&urp.Email, "email", "", "email")
+				fs.StringVar(&urp.Handle, "handle", "", "handle, without the '@'")
+				return action{
+					check: func(args []string) error {
+						if err := noArgs(args); err != nil {
+							return err
+						}
+						return readAdminPayload(urp)
+					},
+					run: func(conf *config.AppConfig, args []string) error {
+						return createAdmin(conf, urp)
+					},
+				}
+			},
+		},
+	},
+}
+
+// reads the password, and validates the payload
+func readAdminPayload(urp *models.UserRegisterPayload) error {
+	// the password isn't a flag, so that it doesn't end up in the shell history
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("read password from stdin: %w", err)
+	}
+	urp.Password = strings.TrimRight(line, "\r\n")
+	validationErrs, err := urp.Validate()
+	if err != nil {
+		return err
+	}
+	if len(validationErrs) > 0 {
+		return fmt.Errorf("%s", strings.Join(validationErrs, "\n"))
+	}
+	return nil
+}
+
+func createAdmin(conf *config.AppConfig, urp *models.UserRegisterPayload) error {
+	pg, err := connectDB(conf)
+	if err != nil {
+		return err
+	}
+	defer pg.Db.Close()
+	userRepo := models.NewUserRepo(pg.Db, sqlbuild.New())
+	userId, err := userRepo.RegisterWithRole(urp, models.ROLE_ADMIN)
+	if err != nil {
+		if pqError, ok := err.(*pq.Error); ok && pqError.Code == postgres.ERROR_UNIQUE_VIOLATION {
+			return fmt.Errorf("this user already exists")
+		}
+		return err
+	}
+	fmt.Println(userId)
+	return nil
+}
@@ -1,62 +1,373 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	_ROOT_PRIVILEGED_PORTS_END = uint(1025)
+	_PORTS_END                 = uint(65535)
+	// bytes. a shorter HMAC secret can be brute forced from a single token. 32 is the size of
+	// the HS256 hash.
+	_MIN_HMAC_SECRET_LENGTH = 32
 )
 
 type AppConfig struct {
 	RelationalDB ConfigRelationalDB
 	Auth         ConfigAuth
 	HttpServer   ConfigHttpServer
+	Privileges   ConfigPrivileges
+	Retention    ConfigRetention
 }
 
+// the defaults. everything that isn't set in the config file, the environment, or a flag is
+// what's here. see Load.
 func NewAppConfig() *AppConfig {
 	return &AppConfig{
-		RelationalDB: ConfigRelationalDB{},
-		Auth:         ConfigAuth{},
-		HttpServer:   ConfigHttpServer{},
+		RelationalDB: ConfigRelationalDB{
+			Name:   "postgresql",
+			Host:   "localhost",
+			Port:   5432,
+			User:   "postgres",
+			Ssl:    "disable",
+			DbName: "qa",
+			// no default password
+		},
+		Auth: ConfigAuth{
+			Jwt: ConfigJwt{
+				// no default keys. ActiveKid defaults to the kid of the only key, if there is one.
+				Issuer:    "qa-api",
+				Audience:  "qa-api",
+				Algorithm: "HS256",
+			},
+		},
+		HttpServer: ConfigHttpServer{
+			HttpVersion:            "HTTP/2",
+			Port:                   8000,
+			Domain:                 "127.0.0.1",
+			ReadTimeoutSeconds:     15,
+			WriteTimeoutSeconds:    30,
+			IdleTimeoutSeconds:     120,
+			ShutdownTimeoutSeconds: 30,
+		},
+		Privileges: ConfigPrivileges{
+			Downvote:           ConfigPrivilege{MinAccountAgeDays: 3, MinPosts: 1, MinNetUpvotes: 5},
+			PostLinks:          ConfigPrivilege{MinAccountAgeDays: 1, MinNetUpvotes: 1},
+			QuestionsPerDay:    5,
+			UnlimitedQuestions: ConfigPrivilege{MinAccountAgeDays: 7, MinPosts: 5, MinNetUpvotes: 10},
+		},
+		Retention: ConfigRetention{
+			GraceWindowHours: 72,
+			// the purge is off unless it's set
+			PurgeAfterDays:       0,
+			PurgeIntervalMinutes: 60,
+		},
 	}
 }
 
-func (a *AppConfig) Parse(file string) error {
-	if ext := filepath.Ext(file); ext != ".json" {
-		return fmt.Errorf("expected a json file, got %s", ext)
+// every problem of a config, not just the first one
+type ValidationError struct {
+	Problems []string
+}
+
+func (v *ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(v.Problems, "\n  - ")
+}
+
+// sections of the config, the top level fields of AppConfig. a command that only uses some of
+// them, like migrate, only validates those. see Validate.
+const (
+	SECTION_RELATIONAL_DB = "relationalDB"
+	SECTION_AUTH          = "auth"
+	SECTION_HTTP_SERVER   = "httpServer"
+	SECTION_PRIVILEGES    = "privileges"
+	SECTION_RETENTION     = "retention"
+)
+
+// no sections means every section
+func hasSection(sections []string, section string) bool {
+	if len(sections) == 0 {
+		return true
 	}
-	bx, err := os.ReadFile(file)
-	if err != nil {
-		return err
+	for _, v := range sections {
+		if v == section {
+			return true
+		}
 	}
-	err = json.Unmarshal(bx, a)
-	if err != nil {
-		return err
+	return false
+}
+
+// validates the sections, or every section if there are none. returns a *ValidationError, or
+// nil. problems are prefixed with the path of the field, like the paths of Load overrides.
+func (a *AppConfig) Validate(sections ...string) error {
+	validators := []struct {
+		section  string
+		validate func() []string
+	}{
+		{SECTION_RELATIONAL_DB, a.RelationalDB.validate},
+		{SECTION_AUTH, a.Auth.Jwt.validate},
+		{SECTION_HTTP_SERVER, a.HttpServer.validate},
+		{SECTION_PRIVILEGES, a.Privileges.validate},
+		{SECTION_RETENTION, a.Retention.validate},
 	}
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if len(jwtSecret) == 0 {
-		return fmt.Errorf("*AppConfig.Parse: environment variable 'JWT_SECRET' is not set")
+	problems := []string{}
+	for _, v := range validators {
+		if hasSection(sections, v.section) {
+			problems = append(problems, v.validate()...)
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
 	}
-	a.Auth.Jwt.SecretKey = []byte(jwtSecret)
 	return nil
 }
 
 type ConfigRelationalDB struct {
 	Name, Host, User, Ssl, DbName string
 	Port                          uint
+	// POSTGRES_PWD, or QA_RELATIONAL_DB_PASSWORD. better "file:<path>" than the password itself
+	// in a config file.
+	Password string
+}
+
+func (c *ConfigRelationalDB) validate() []string {
+	problems := []string{}
+	required := map[string]string{"host": c.Host, "user": c.User, "dbName": c.DbName, "password": c.Password}
+	for _, name := range []string{"host", "user", "dbName", "password"} {
+		if len(required[name]) == 0 {
+			problems = append(problems, "relationalDB."+name+": required")
+		}
+	}
+	if c.Port == 0 || c.Port > _PORTS_END {
+		problems = append(problems, fmt.Sprintf("relationalDB.port: out of range [1,%d]: %d", _PORTS_END, c.Port))
+	}
+	return problems
 }
 
 type ConfigAuth struct {
 	Jwt ConfigJwt
 }
 
+// Rotating the signing key without logging everybody out:
+//  1. add the new key to Secrets (or KeyFiles), keep the old one active, and deploy. every
+//     server can verify tokens signed with the new key now.
+//  2. make the new key active (ActiveKid), and deploy. new tokens are signed with it.
+//  3. after AT_EXPIRY, no valid token is signed with the old key. remove it.
 type ConfigJwt struct {
-	SecretKey []byte
+	// HMAC secrets, a comma separated list of 'kid:secret' pairs, like 'k2:secret2,k1:secret1'.
+	// JWT_KEYS, or QA_AUTH_JWT_SECRETS. a secret can't contain ',', it would be split into two
+	// pairs. a kid can't contain ':'. generate secrets without them, like with
+	// 'openssl rand -hex 32'.
+	Secrets string
+	// a single HMAC secret, with the kid 'default'. only used if Secrets is empty. JWT_SECRET, or
+	// QA_AUTH_JWT_SECRET.
+	Secret string
+	// parsed from Secrets, or Secret by Load
+	Keys []ConfigJwtKey `json:"-"`
+	// RSA, or Ed25519 keys. public keys of these are published at /.well-known/jwks.json, so that
+	// other services can verify access tokens without knowing a secret.
+	KeyFiles []ConfigJwtKeyFile
+	// kid of the key that signs new tokens. JWT_ACTIVE_KID, or QA_AUTH_JWT_ACTIVE_KID.
+	ActiveKid string
+	// 'iss', and 'aud' claims of access tokens
+	Issuer, Audience string
+	// signing algorithm of the HMAC keys. algorithms of KeyFiles come from the types of the keys
+	// (RS256 for RSA, EdDSA for Ed25519).
+	Algorithm string
+}
+
+type ConfigJwtKeyFile struct {
+	Kid string
+	// a PEM encoded private key (PKCS #1, or PKCS #8), or a public key (PKIX). a public key can
+	// only verify tokens, which is useful for a key that is being rotated out.
+	File string
+}
+
+type ConfigJwtKey struct {
+	Kid    string
+	Secret []byte
+}
+
+// fills Keys from Secrets, or Secret. with a single key, it's the active one.
+func (c *ConfigJwt) parseKeys() []string {
+	problems := []string{}
+	c.Keys = nil
+	if len(c.Secrets) > 0 {
+		seen := map[string]bool{}
+		for _, pair := range strings.Split(c.Secrets, ",") {
+			i := strings.Index(pair, ":")
+			if i <= 0 || i == len(pair)-1 {
+				problems = append(problems, "auth.jwt.secrets: invalid 'kid:secret' pair")
+				continue
+			}
+			kid, secret := strings.TrimSpace(pair[:i]), pair[i+1:]
+			if seen[kid] {
+				problems = append(problems, fmt.Sprintf("auth.jwt.secrets: duplicate kid '%s'", kid))
+				continue
+			}
+			seen[kid] = true
+			c.Keys = append(c.Keys, ConfigJwtKey{Kid: kid, Secret: []byte(secret)})
+		}
+	} else if len(c.Secret) > 0 {
+		c.Keys = []ConfigJwtKey{{Kid: "default", Secret: []byte(c.Secret)}}
+	}
+	if len(c.ActiveKid) == 0 && len(c.Keys)+len(c.KeyFiles) == 1 {
+		for _, v := range c.Keys {
+			c.ActiveKid = v.Kid
+		}
+		for _, v := range c.KeyFiles {
+			c.ActiveKid = v.Kid
+		}
+	}
+	return problems
+}
+
+func (c *ConfigJwt) validate() []string {
+	problems := []string{}
+	if len(c.Keys) == 0 && len(c.KeyFiles) == 0 {
+		problems = append(problems, "auth.jwt: no keys. set secrets, secret, or keyFiles")
+	}
+	if len(c.Issuer) == 0 {
+		problems = append(problems, "auth.jwt.issuer: required")
+	}
+	if len(c.Audience) == 0 {
+		problems = append(problems, "auth.jwt.audience: required")
+	}
+	switch c.Algorithm {
+	case "HS256", "HS384", "HS512":
+	default:
+		problems = append(problems, fmt.Sprintf("auth.jwt.algorithm: unsupported algorithm '%s'. need one of 'HS256', 'HS384', 'HS512'", c.Algorithm))
+	}
+	kids := map[string]bool{}
+	for _, v := range c.Keys {
+		kids[v.Kid] = true
+		if len(v.Secret) < _MIN_HMAC_SECRET_LENGTH {
+			problems = append(problems, fmt.Sprintf("auth.jwt: the secret of the kid '%s' is too short. need at least %d bytes", v.Kid, _MIN_HMAC_SECRET_LENGTH))
+		}
+	}
+	for _, v := range c.KeyFiles {
+		if len(v.Kid) == 0 || len(v.File) == 0 {
+			problems = append(problems, "auth.jwt.keyFiles: a key file needs both a kid, and a file")
+			continue
+		}
+		if kids[v.Kid] {
+			problems = append(problems, fmt.Sprintf("auth.jwt.keyFiles: duplicate kid '%s'", v.Kid))
+		}
+		kids[v.Kid] = true
+	}
+	if len(kids) > 0 && !kids[c.ActiveKid] {
+		problems = append(problems, fmt.Sprintf("auth.jwt.activeKid: no key with the kid '%s'", c.ActiveKid))
+	}
+	return problems
 }
 
 type ConfigHttpServer struct {
 	HttpVersion string
-	Port        string
+	Port        uint
 	UseTLS      bool
-	DevMode     bool
+	// required with UseTLS
+	CertFile, KeyFile string
+	DevMode           bool
+	// domain of the cookies. DOMAIN, or QA_HTTP_SERVER_DOMAIN.
+	Domain string
+	// of reading a whole request, writing a response, and keeping an idle connection open. 0 is
+	// no timeout.
+	ReadTimeoutSeconds, WriteTimeoutSeconds, IdleTimeoutSeconds int64
+	// how long in-flight requests have to finish on shutdown, before their connections are closed
+	ShutdownTimeoutSeconds int64
+}
+
+func (c *ConfigHttpServer) validate() []string {
+	problems := []string{}
+	if !(c.Port >= _ROOT_PRIVILEGED_PORTS_END && c.Port <= _PORTS_END) {
+		problems = append(problems, fmt.Sprintf("httpServer.port: out of range [%d,%d]: %d", _ROOT_PRIVILEGED_PORTS_END, _PORTS_END, c.Port))
+	}
+	if c.UseTLS && len(c.CertFile) == 0 {
+		problems = append(problems, "httpServer.certFile: required with useTLS")
+	}
+	if c.UseTLS && len(c.KeyFile) == 0 {
+		problems = append(problems, "httpServer.keyFile: required with useTLS")
+	}
+	if len(c.Domain) == 0 {
+		problems = append(problems, "httpServer.domain: required")
+	}
+	if c.ReadTimeoutSeconds < 0 || c.WriteTimeoutSeconds < 0 || c.IdleTimeoutSeconds < 0 {
+		problems = append(problems, "httpServer: timeouts can't be negative")
+	}
+	if c.ShutdownTimeoutSeconds <= 0 {
+		problems = append(problems, "httpServer.shutdownTimeoutSeconds: must be positive")
+	}
+	return problems
+}
+
+// what a new account can't do until it reaches the thresholds. moderators have every privilege.
+type ConfigPrivileges struct {
+	Downvote ConfigPrivilege
+	// links in questions, answers, and comments
+	PostLinks ConfigPrivilege
+	// a user without the UnlimitedQuestions privilege can ask at most QuestionsPerDay questions
+	// in 24 hours. 0 means no limit.
+	QuestionsPerDay    int64
+	UnlimitedQuestions ConfigPrivilege
+}
+
+// a user has the privilege if they reach all of the thresholds
+type ConfigPrivilege struct {
+	// days since users.created_at
+	MinAccountAgeDays int64
+	// non-deleted questions, and answers
+	MinPosts int64
+	// upvotes minus downvotes received on non-deleted questions, and answers
+	MinNetUpvotes int64
+}
+
+func (c *ConfigPrivileges) validate() []string {
+	problems := []string{}
+	if c.QuestionsPerDay < 0 {
+		problems = append(problems, "privileges.questionsPerDay: can't be negative")
+	}
+	privileges := []struct {
+		name string
+		p    ConfigPrivilege
+	}{{"downvote", c.Downvote}, {"postLinks", c.PostLinks}, {"unlimitedQuestions", c.UnlimitedQuestions}}
+	for _, v := range privileges {
+		if v.p.MinAccountAgeDays < 0 || v.p.MinPosts < 0 {
+			problems = append(problems, "privileges."+v.name+": thresholds can't be negative")
+		}
+	}
+	return problems
+}
+
+// soft-deleted questions, answers, and users
+type ConfigRetention struct {
+	// authors can restore their questions, and answers, and users can restore their accounts
+	// for this long after deleting them. moderators can restore anytime before the purge.
+	GraceWindowHours int64
+	// rows soft-deleted longer than this are deleted for good. 0 disables the purge.
+	PurgeAfterDays int64
+	// how often the purge job runs
+	PurgeIntervalMinutes int64
+}
+
+func (c *ConfigRetention) validate() []string {
+	if c.GraceWindowHours < 0 || c.PurgeAfterDays < 0 || c.PurgeIntervalMinutes < 0 {
+		return []string{"retention: can't be negative"}
+	}
+	problems := []string{}
+	if c.PurgeAfterDays == 0 {
+		return problems
+	}
+	if c.PurgeAfterDays*24 < c.GraceWindowHours {
+		problems = append(problems, "retention.purgeAfterDays: can't be shorter than graceWindowHours")
+	}
+	if c.PurgeIntervalMinutes == 0 {
+		problems = append(problems, "retention.purgeIntervalMinutes: required if purgeAfterDays is set")
+	}
+	return problems
+}
+
+func (c *ConfigRetention) GraceWindow() time.Duration {
+	return time.Duration(c.GraceWindowHours) * time.Hour
 }
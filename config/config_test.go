@@ -0,0 +1,55 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJwtSecretMinLength(t *testing.T) {
+	tests := []struct {
+		secrets string
+		ok      bool
+	}{
+		{"k1:" + strings.Repeat("s", _MIN_HMAC_SECRET_LENGTH), true},
+		{"k1:" + strings.Repeat("s", _MIN_HMAC_SECRET_LENGTH-1), false},
+		{"k1:x", false},
+		{"k2:" + strings.Repeat("s", _MIN_HMAC_SECRET_LENGTH) + ",k1:x", false},
+	}
+	for _, tt := range tests {
+		c := NewAppConfig().Auth.Jwt
+		c.Secrets = tt.secrets
+		c.ActiveKid = "k1"
+		problems := append(c.parseKeys(), c.validate()...)
+		if ok := len(problems) == 0; ok != tt.ok {
+			t.Errorf("secrets '%s': expected ok %v, got problems %v", tt.secrets, tt.ok, problems)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	a := NewAppConfig()
+	a.HttpServer.Port = 80
+	a.Privileges.QuestionsPerDay = -1
+	a.Retention.PurgeAfterDays = 1
+	err := a.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	// one of every section
+	expected := []string{
+		"relationalDB.password: required",
+		"auth.jwt: no keys. set secrets, secret, or keyFiles",
+		"httpServer.port: out of range [1025,65535]: 80",
+		"privileges.questionsPerDay: can't be negative",
+		"retention.purgeAfterDays: can't be shorter than graceWindowHours",
+	}
+	if strings.Join(verr.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(verr.Problems, "\n"))
+	}
+	// only the sections
+	verr = a.Validate(SECTION_HTTP_SERVER, SECTION_RETENTION).(*ValidationError)
+	if len(verr.Problems) != 2 {
+		t.Fatalf("expected the problems of 2 sections, got %v", verr.Problems)
+	}
+}
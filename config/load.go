@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// string values with this prefix are replaced with the contents of the file after it, like
+// "file:/run/secrets/postgres". a trailing newline is trimmed.
+const _SECRET_FILE_PREFIX = "file:"
+
+// environment variables from before QA_*. QA_* variables override them.
+var legacyEnv = map[string]string{
+	"POSTGRES_PWD":   "relationalDB.password",
+	"JWT_KEYS":       "auth.jwt.secrets",
+	"JWT_SECRET":     "auth.jwt.secret",
+	"JWT_ACTIVE_KID": "auth.jwt.activeKid",
+	"DOMAIN":         "httpServer.domain",
+}
+
+// builds the config from these layers. a later layer overrides an earlier one.
+//  1. the defaults, see NewAppConfig
+//  2. the file, unless it's empty. .json, .yaml, .yml, or .toml. keys are case insensitive.
+//  3. the environment variables in legacyEnv
+//  4. QA_* environment variables. every field has one: QA_ + the path of the field in upper
+//     snake case, like QA_HTTP_SERVER_PORT for httpServer.port.
+//  5. overrides, 'path=value' pairs from flags, like 'httpServer.port=9000'
+//
+// lists, like auth.jwt.keyFiles, are JSON in the environment, and in overrides. then secret
+// files are read (see _SECRET_FILE_PREFIX), and the sections are validated, or every section if
+// there are none. the other sections are left as they are. the error lists every problem of
+// every layer.
+func Load(file string, overrides []string, sections ...string) (*AppConfig, error) {
+	a := NewAppConfig()
+	problems := []string{}
+	if len(file) > 0 {
+		unknown, err := decodeFile(a, file)
+		if err != nil {
+			return nil, fmt.Errorf("config file '%s': %w", file, err)
+		}
+		for _, k := range unknown {
+			problems = append(problems, fmt.Sprintf("config file '%s': unknown field '%s'", file, k))
+		}
+	}
+	fields := fieldsOf(a)
+	byPath := map[string]field{}
+	for _, f := range fields {
+		byPath[strings.ToLower(f.path)] = f
+	}
+	for env, path := range legacyEnv {
+		if v, ok := os.LookupEnv(env); ok {
+			if err := byPath[strings.ToLower(path)].set(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", env, err.Error()))
+			}
+		}
+	}
+	for _, f := range fields {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", f.env, err.Error()))
+			}
+		}
+	}
+	for _, o := range overrides {
+		i := strings.Index(o, "=")
+		if i <= 0 {
+			problems = append(problems, fmt.Sprintf("override '%s': expected 'path=value'", o))
+			continue
+		}
+		f, ok := byPath[strings.ToLower(o[:i])]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("override '%s': no such field", o[:i]))
+			continue
+		}
+		if err := f.set(o[i+1:]); err != nil {
+			problems = append(problems, fmt.Sprintf("override '%s': %s", o[:i], err.Error()))
+		}
+	}
+	for _, f := range fields {
+		if !hasSection(sections, f.section()) {
+			continue
+		}
+		if err := f.readSecretFile(); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", f.path, err.Error()))
+		}
+	}
+	if hasSection(sections, SECTION_AUTH) {
+		problems = append(problems, a.Auth.Jwt.parseKeys()...)
+	}
+	if err := a.Validate(sections...); err != nil {
+		problems = append(problems, err.(*ValidationError).Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return a, nil
+}
+
+// every format is decoded to JSON first, so that keys match the fields the same way. returns the
+// keys that aren't fields, like a misspelled 'httpServer.prot', instead of ignoring them.
+func decodeFile(a *AppConfig, file string) ([]string, error) {
+	bx, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	switch ext := strings.ToLower(filepath.Ext(file)); ext {
+	case ".json":
+		// numbers stay as they are in the file, instead of becoming float64s
+		dec := json.NewDecoder(bytes.NewReader(bx))
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(bx, &raw); err != nil {
+			return nil, err
+		}
+		raw = stringKeys(raw)
+	case ".toml":
+		m := map[string]interface{}{}
+		if err := toml.Unmarshal(bx, &m); err != nil {
+			return nil, err
+		}
+		raw = m
+	default:
+		return nil, fmt.Errorf("expected a .json, .yaml, .yml, or .toml file, got '%s'", ext)
+	}
+	unknown := removeUnknownKeys(raw, reflect.TypeOf(*a), "")
+	sort.Strings(unknown)
+	if bx, err = json.Marshal(raw); err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(bx))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(a); err != nil {
+		return nil, err
+	}
+	return unknown, nil
+}
+
+// removes the keys of the objects in v that aren't fields of t, and returns their paths, so
+// that every unknown key is reported, not just the first one the decoder finds. keys match
+// the fields case insensitively, like in encoding/json. values of the wrong type are left to
+// the decoder.
+func removeUnknownKeys(v interface{}, t reflect.Type, path string) []string {
+	res := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return res
+		}
+		for k, val := range m {
+			p := k
+			if len(path) > 0 {
+				p = path + "." + k
+			}
+			sf, ok := fieldByKey(t, k)
+			if !ok {
+				res = append(res, p)
+				delete(m, k)
+				continue
+			}
+			res = append(res, removeUnknownKeys(val, sf.Type, p)...)
+		}
+	case reflect.Slice:
+		l, ok := v.([]interface{})
+		if !ok {
+			return res
+		}
+		for i, val := range l {
+			res = append(res, removeUnknownKeys(val, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+	return res
+}
+
+// fields with the json:"-" tag aren't in the config file
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Tag.Get("json") != "-" && strings.EqualFold(sf.Name, key) {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// yaml decodes maps to map[interface{}]interface{}, which can't be encoded to JSON
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			m[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	}
+	return v
+}
+
+type field struct {
+	// like httpServer.port
+	path string
+	// like QA_HTTP_SERVER_PORT
+	env   string
+	value reflect.Value
+}
+
+// the fields that aren't structs, except the ones that aren't in the config file
+func fieldsOf(a *AppConfig) []field {
+	res := []field{}
+	var walk func(v reflect.Value, path, env string)
+	walk = func(v reflect.Value, path, env string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.Tag.Get("json") == "-" {
+				continue
+			}
+			p := strings.ToLower(sf.Name[:1]) + sf.Name[1:]
+			if len(path) > 0 {
+				p = path + "." + p
+			}
+			e := env + "_" + upperSnake(sf.Name)
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), p, e)
+				continue
+			}
+			res = append(res, field{path: p, env: e, value: v.Field(i)})
+		}
+	}
+	walk(reflect.ValueOf(a).Elem(), "", "QA")
+	return res
+}
+
+// RelationalDB -> RELATIONAL_DB, UseTLS -> USE_TLS
+func upperSnake(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func (f field) set(s string) error {
+	v := f.value
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid bool '%s'", s)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || v.OverflowInt(n) {
+			return fmt.Errorf("invalid integer '%s'", s)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || v.OverflowUint(n) {
+			return fmt.Errorf("invalid unsigned integer '%s'", s)
+		}
+		v.SetUint(n)
+	default:
+		// a new value, so that a list isn't merged with the one from the file
+		ptr := reflect.New(v.Type())
+		if err := json.Unmarshal([]byte(s), ptr.Interface()); err != nil {
+			return fmt.Errorf("invalid JSON: %s", err.Error())
+		}
+		v.Set(ptr.Elem())
+	}
+	return nil
+}
+
+// like httpServer
+func (f field) section() string {
+	return strings.SplitN(f.path, ".", 2)[0]
+}
+
+func (f field) readSecretFile() error {
+	if f.value.Kind() != reflect.String || !strings.HasPrefix(f.value.String(), _SECRET_FILE_PREFIX) {
+		return nil
+	}
+	bx, err := os.ReadFile(strings.TrimPrefix(f.value.String(), _SECRET_FILE_PREFIX))
+	if err != nil {
+		return err
+	}
+	f.value.SetString(strings.TrimRight(string(bx), "\r\n"))
+	return nil
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeFileKnownFields(t *testing.T) {
+	a := NewAppConfig()
+	unknown, err := decodeFile(a, "conf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unknown) > 0 {
+		t.Fatalf("unknown fields in conf.json: %v", unknown)
+	}
+	if a.HttpServer.Port != 8000 || a.RelationalDB.DbName != "qa" {
+		t.Fatalf("conf.json not decoded: %+v", a)
+	}
+}
+
+func TestDecodeFileReportsEveryUnknownKey(t *testing.T) {
+	files := map[string]string{
+		"conf.json": `{
+			"httpServer": {"prot": 9000, "port": 9001},
+			"auth": {"jwt": {"keys": [], "keyFiles": [{"kid": "r1", "flie": "r1.pem"}]}},
+			"logging": {}
+		}`,
+		"conf.yaml": `
+httpServer:
+  prot: 9000
+  port: 9001
+auth:
+  jwt:
+    keys: []
+    keyFiles:
+      - kid: r1
+        flie: r1.pem
+logging: {}
+`,
+		"conf.toml": `
+[httpServer]
+prot = 9000
+port = 9001
+
+[auth.jwt]
+keys = []
+
+[[auth.jwt.keyFiles]]
+kid = "r1"
+flie = "r1.pem"
+
+[logging]
+`,
+	}
+	// Keys is json:"-", so it can't be set from the file
+	expected := []string{"auth.jwt.keyFiles[0].flie", "auth.jwt.keys", "httpServer.prot", "logging"}
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name)
+			if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			a := NewAppConfig()
+			unknown, err := decodeFile(a, file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(unknown, expected) {
+				t.Fatalf("expected %v, got %v", expected, unknown)
+			}
+			// the known fields are still decoded
+			if a.HttpServer.Port != 9001 || len(a.Auth.Jwt.KeyFiles) != 1 || a.Auth.Jwt.KeyFiles[0].Kid != "r1" {
+				t.Fatalf("known fields not decoded: %+v", a)
+			}
+		})
+	}
+}
+
+// like t.Setenv, which is newer than the go version of the module
+func setenv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, old)
+			return
+		}
+		os.Unsetenv(key)
+	})
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+var testSecret = strings.Repeat("s", _MIN_HMAC_SECRET_LENGTH)
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "conf.json", `{
+		"relationalDB": {"password": "file", "user": "file"},
+		"auth": {"jwt": {"secrets": "k1:`+testSecret+`", "activeKid": "k1", "issuer": "file"}},
+		"httpServer": {"port": 8001, "domain": "file"}
+	}`)
+	// relationalDB.password is in every layer
+	setenv(t, "POSTGRES_PWD", "legacy")
+	setenv(t, "QA_RELATIONAL_DB_PASSWORD", "qa")
+	// httpServer.domain is in every layer but the overrides
+	setenv(t, "DOMAIN", "legacy")
+	setenv(t, "QA_HTTP_SERVER_DOMAIN", "qa")
+	// relationalDB.user, and auth.jwt.issuer skip the legacy environment variables
+	setenv(t, "QA_RELATIONAL_DB_USER", "qa")
+	setenv(t, "QA_AUTH_JWT_ISSUER", "qa")
+	a, err := Load(file, []string{"relationalDB.password=override", "auth.jwt.issuer=override"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, got, expected string
+	}{
+		{"relationalDB.password", a.RelationalDB.Password, "override"},
+		{"httpServer.domain", a.HttpServer.Domain, "qa"},
+		{"relationalDB.user", a.RelationalDB.User, "qa"},
+		{"auth.jwt.issuer", a.Auth.Jwt.Issuer, "override"},
+		{"auth.jwt.audience", a.Auth.Jwt.Audience, "qa-api"},
+		{"relationalDB.host", a.RelationalDB.Host, "localhost"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.expected {
+			t.Errorf("%s: expected '%s', got '%s'", tt.path, tt.expected, tt.got)
+		}
+	}
+	if a.HttpServer.Port != 8001 {
+		t.Errorf("httpServer.port: expected 8001, got %d", a.HttpServer.Port)
+	}
+}
+
+func TestLoadLegacyEnvOverridesFile(t *testing.T) {
+	file := writeFile(t, "conf.json", `{"relationalDB": {"password": "file"}, "auth": {"jwt": {"secret": "`+testSecret+`"}}}`)
+	setenv(t, "POSTGRES_PWD", "legacy")
+	a, err := Load(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.RelationalDB.Password != "legacy" {
+		t.Fatalf("expected 'legacy', got '%s'", a.RelationalDB.Password)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	password := writeFile(t, "password", "hunter2\n")
+	secrets := writeFile(t, "secrets", "k2:"+testSecret+"2,k1:"+testSecret+"1\r\n")
+	a, err := Load("", []string{
+		"relationalDB.password=file:" + password,
+		"auth.jwt.secrets=file:" + secrets,
+		"auth.jwt.activeKid=k1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.RelationalDB.Password != "hunter2" {
+		t.Errorf("relationalDB.password: expected 'hunter2', got '%s'", a.RelationalDB.Password)
+	}
+	// the keys are parsed from the contents of the file
+	keys := a.Auth.Jwt.Keys
+	if len(keys) != 2 || keys[0].Kid != "k2" || string(keys[1].Secret) != testSecret+"1" {
+		t.Errorf("auth.jwt.keys: unexpected keys %+v", keys)
+	}
+
+	_, err = Load("", []string{
+		"relationalDB.password=file:" + filepath.Join(t.TempDir(), "missing"),
+		"auth.jwt.secret=" + testSecret,
+	})
+	verr, ok := err.(*ValidationError)
+	if !ok || len(verr.Problems) != 1 || !strings.HasPrefix(verr.Problems[0], "relationalDB.password: ") {
+		t.Fatalf("expected a problem of relationalDB.password, got %v", err)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	file := writeFile(t, "conf.json", `{"httpServer": {"prot": 1}}`)
+	_, err := Load(file, []string{
+		"httpServer.port=80",
+		"httpServer.domain=",
+		"relationalDB.port=not-a-number",
+		"retention.purgeAfterDays=1",
+		"no.such.field=1",
+	})
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+	expected := []string{
+		"unknown field 'httpServer.prot'",
+		"override 'relationalDB.port': invalid unsigned integer 'not-a-number'",
+		"override 'no.such.field': no such field",
+		"relationalDB.password: required",
+		"auth.jwt: no keys",
+		"httpServer.port: out of range",
+		"httpServer.domain: required",
+		"retention.purgeAfterDays: can't be shorter than graceWindowHours",
+	}
+	all := strings.Join(verr.Problems, "\n")
+	for _, e := range expected {
+		if !strings.Contains(all, e) {
+			t.Errorf("missing problem '%s' in:\n%s", e, all)
+		}
+	}
+}
+
+func TestLoadValidatesOnlyTheSections(t *testing.T) {
+	// no jwt keys, and an invalid port, but only the database is used
+	a, err := Load("", []string{"relationalDB.password=pwd", "httpServer.port=1"}, SECTION_RELATIONAL_DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.RelationalDB.Password != "pwd" {
+		t.Fatalf("expected 'pwd', got '%s'", a.RelationalDB.Password)
+	}
+	if _, err := Load("", []string{"httpServer.port=1"}, SECTION_RELATIONAL_DB); err == nil {
+		t.Fatal("missing password accepted")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/betelgeuse-7/qa/service/badges"
+	"github.com/betelgeuse-7/qa/service/privileges"
 	"github.com/betelgeuse-7/qa/storage/models"
 	"github.com/betelgeuse-7/qa/storage/postgres"
 	"github.com/gin-gonic/gin"
@@ -16,23 +18,45 @@ func (h *Handler) NewAnswer(c *gin.Context) {
 	if err != nil {
 		return
 	}
+	qs, err := h.questionRepo.GetQuestionStatus(questionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no such question"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.NewAnswer: get question status: %s\n", err.Error())
+		return
+	}
+	if qs.DeletedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no such question"})
+		return
+	}
+	if qs.LockedAt != nil && !hasRole(c, models.ROLE_MODERATOR) {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ERROR_QUESTION_LOCKED})
+		return
+	}
 	newAnswerPayload := models.NewAnswerPayload{}
-	newAnswerPayload.ToQuestion = questionId
-	answerBy := c.GetInt64(ContextUserIdKey)
-	newAnswerPayload.AnswerBy = answerBy
 	if err = c.BindJSON(&newAnswerPayload); err != nil {
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("*Handler.NewAnswer: bind json: %s\n", err.Error())
 		return
 	}
+	// after binding, so that the body can't answer another question, or answer as another user
+	newAnswerPayload.ToQuestion = questionId
+	answerBy := c.GetInt64(ContextUserIdKey)
+	newAnswerPayload.AnswerBy = answerBy
 	if len(newAnswerPayload.Text) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing text"})
 		return
 	}
+	if err := checkLinkPrivilege(h, c, newAnswerPayload.Text); err != nil {
+		return
+	}
 	nar, err := h.answerRepo.NewAnswer(newAnswerPayload)
 	if err != nil {
 		// no question with provided question id
-		if err := err.(*pq.Error); err.Code == postgres.ERROR_FOREIGN_KEY_VIOLATION {
+		if pqError, ok := err.(*pq.Error); ok && pqError.Code == postgres.ERROR_FOREIGN_KEY_VIOLATION {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no such question"})
 			return
 		}
@@ -40,37 +64,23 @@ func (h *Handler) NewAnswer(c *gin.Context) {
 		h.logger.Error("*Handler.NewAnswer: *Handler.answerRepo.NewAnswer: %s\n", err.Error())
 		return
 	}
+	ev := badges.Event{Kind: badges.EVENT_ANSWER_CREATED, ActorId: answerBy, PostType: "answer", PostId: nar.AnswerId}
 	msg := gin.H{"message": "answered successfully", "record": gin.H{
 		"text": nar.Text, "answered_at": nar.CreatedAt,
-	}}
+	}, "badges": awardBadges(h, ev)}
 	c.JSON(http.StatusCreated, msg)
 }
 
+// moderators can delete any answer
 func (h *Handler) DeleteAnswer(c *gin.Context) {
 	answerId, err := getInt64IdParam(c)
 	if err != nil {
 		return
 	}
-	userId := c.GetInt64(ContextUserIdKey)
-	as, err := h.answerRepo.GetAnswerStatus(answerId)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
-			return
-		}
-		c.Status(http.StatusInternalServerError)
-		h.logger.Error("*Handler.DeleteAnswer: GetAnswerStatus: %s\n", err.Error())
-		return
-	}
-	if as.UserId != userId {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
-		return
-	}
-	if as.DeletedAt != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+	if err := checkUserCanModifyAnswer(h, c, answerId); err != nil {
 		return
 	}
-	err = h.answerRepo.DeleteAnswer(answerId)
+	err = h.answerRepo.DeleteAnswer(answerId, c.GetInt64(ContextUserIdKey))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
@@ -84,24 +94,36 @@ func (h *Handler) DeleteAnswer(c *gin.Context) {
 	c.JSON(http.StatusOK, msg)
 }
 
-func (h *Handler) UpdateAnswer(c *gin.Context) {
+func (h *Handler) ViewAnswer(c *gin.Context) {
 	answerId, err := getInt64IdParam(c)
 	if err != nil {
 		return
 	}
-	userId := c.GetInt64(ContextUserIdKey)
-	ok, err := h.answerRepo.AnswerBelongsToUser(answerId, userId)
+	answer, err := h.answerRepo.GetAnswer(answerId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such answer"})
 			return
 		}
 		c.Status(http.StatusInternalServerError)
-		h.logger.Error("*Handler.UpdateAnswer: answer belongs to user, err: %s\n", err.Error())
+		h.logger.Error("*Handler.ViewAnswer: %s\n", err.Error())
 		return
 	}
-	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+	setETag(c, answer.Version)
+	c.JSON(http.StatusOK, answer)
+}
+
+// moderators can update any answer
+func (h *Handler) UpdateAnswer(c *gin.Context) {
+	answerId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserCanModifyAnswer(h, c, answerId); err != nil {
+		return
+	}
+	version, err := getIfMatchVersion(c)
+	if err != nil {
 		return
 	}
 	var uap models.UpdateAnswerPayload
@@ -114,16 +136,140 @@ func (h *Handler) UpdateAnswer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing text"})
 		return
 	}
+	if len(uap.Summary) > models.MAX_EDIT_SUMMARY_LENGTH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "summary is too long"})
+		return
+	}
+	if err := checkLinkPrivilege(h, c, uap.Text); err != nil {
+		return
+	}
+	uap.EditedBy = c.GetInt64(ContextUserIdKey)
+	uap.IfVersion = version
 	uar, err := h.answerRepo.UpdateAnswer(uap, answerId)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
 			return
 		}
+		if errMsg := err.Error(); errMsg == models.ERROR_VERSION_MISMATCH {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": errMsg})
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("*Handler.UpdateAnswer: update answer, err: %s\n", err.Error())
 		return
 	}
-	msg := gin.H{"message": "updated answer", "record": gin.H{"text": uar.Text}}
+	setETag(c, uar.Version)
+	msg := gin.H{"message": "updated answer", "record": gin.H{"text": uar.Text, "version": uar.Version}}
 	c.JSON(http.StatusCreated, msg)
 }
+
+func (h *Handler) UpvoteAnswer(c *gin.Context) {
+	err := voteAnswer(h, c, models.VOTE_UPVOTE)
+	if err != nil {
+		return
+	}
+}
+
+func (h *Handler) DownvoteAnswer(c *gin.Context) {
+	err := voteAnswer(h, c, models.VOTE_DOWNVOTE)
+	if err != nil {
+		return
+	}
+}
+
+func (h *Handler) RetractAnswerVote(c *gin.Context) {
+	err := voteAnswer(h, c, "retract")
+	if err != nil {
+		return
+	}
+}
+
+// type_ is "upvote", "downvote", or "retract". casting the opposite vote switches the vote.
+func voteAnswer(h *Handler, c *gin.Context, type_ string) error {
+	answerId, err := getInt64IdParam(c)
+	if err != nil {
+		return err
+	}
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return fmt.Errorf("err")
+	}
+	ownAnswerErr := ""
+	currentVote := ""
+	switch type_ {
+	case models.VOTE_UPVOTE:
+		ownAnswerErr = models.ERROR_UPVOTE_OWN_ANSWER
+		currentVote, err = h.answerRepo.UpvoteAnswer(answerId, userId)
+	case models.VOTE_DOWNVOTE:
+		if err := checkPrivilege(h, c, privileges.PRIVILEGE_DOWNVOTE); err != nil {
+			return err
+		}
+		ownAnswerErr = models.ERROR_DOWNVOTE_OWN_ANSWER
+		currentVote, err = h.answerRepo.DownvoteAnswer(answerId, userId)
+	case "retract":
+		currentVote, err = h.answerRepo.RetractAnswerVote(answerId, userId)
+	default:
+		return fmt.Errorf("httphandlers.voteAnswer: invalid vote type '%s'", type_)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+			return err
+		}
+		if errMsg := err.Error(); len(ownAnswerErr) > 0 && errMsg == ownAnswerErr {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return err
+		}
+		if errMsg := err.Error(); errMsg == models.ERROR_QUESTION_LOCKED {
+			c.JSON(http.StatusForbidden, gin.H{"error": errMsg})
+			return err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.voteAnswer: %s: %s\n", type_, err.Error())
+		return err
+	}
+	if type_ == "retract" {
+		c.JSON(http.StatusOK, gin.H{"message": "retracted vote", "vote": currentVote})
+		return nil
+	}
+	if currentVote == models.VOTE_UPVOTE {
+		awardBadges(h, badges.Event{Kind: badges.EVENT_ANSWER_UPVOTED, ActorId: userId, PostType: "answer", PostId: answerId})
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": type_ + "d answer", "vote": currentVote})
+	return nil
+}
+
+// the author, or a moderator. only moderators can modify the answers of a locked question.
+func checkUserCanModifyAnswer(h *Handler, c *gin.Context, answerId int64) error {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return fmt.Errorf("err")
+	}
+	as, err := h.answerRepo.GetAnswerStatus(answerId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+			return err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.checkUserCanModifyAnswer: GetAnswerStatus: %s\n", err.Error())
+		return err
+	}
+	isModerator := hasRole(c, models.ROLE_MODERATOR)
+	if as.UserId != userId && !isModerator {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return fmt.Errorf("err")
+	}
+	if as.DeletedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+		return fmt.Errorf("err")
+	}
+	if as.QuestionLockedAt != nil && !isModerator {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ERROR_QUESTION_LOCKED})
+		return fmt.Errorf("err")
+	}
+	return nil
+}
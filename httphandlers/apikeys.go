@@ -0,0 +1,97 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// length of the prefix of a key that is stored, and listed. includes models.API_KEY_PREFIX.
+const _API_KEY_DISPLAY_PREFIX_LENGTH = 8
+
+// the raw key is in the response of this request only. it can't be seen again.
+func (h *Handler) NewApiKey(c *gin.Context) {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+	payload := &models.NewApiKeyPayload{}
+	if err := c.BindJSON(payload); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.NewApiKey: bind json: %s\n", err.Error())
+		return
+	}
+	errs, err := payload.Validate()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.NewApiKey: validate: %s\n", err.Error())
+		return
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	raw, hash, err := jwtauth.NewApiKey(models.API_KEY_PREFIX)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.NewApiKey: new api key: %s\n", err.Error())
+		return
+	}
+	res, err := h.apiKeyRepo.NewApiKey(userId, *payload, hash, raw[:_API_KEY_DISPLAY_PREFIX_LENGTH])
+	if err != nil {
+		if err.Error() == models.ERROR_TOO_MANY_API_KEYS {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too many api keys. revoke one first"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.NewApiKey: new api key: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_key": res, "key": raw})
+}
+
+func (h *Handler) ListApiKeys(c *gin.Context) {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+	keys, err := h.apiKeyRepo.GetApiKeys(userId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ListApiKeys: get api keys: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *Handler) RevokeApiKey(c *gin.Context) {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+	keyId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := h.apiKeyRepo.RevokeApiKey(userId, keyId); err != nil {
+		// don't tell the user that somebody else's key exists
+		if err == sql.ErrNoRows || err.Error() == models.ERROR_API_KEY_NOT_OF_USER {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such api key"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RevokeApiKey: revoke api key: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "revoked api key"})
+}
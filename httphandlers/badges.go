@@ -0,0 +1,28 @@
+package httphandlers
+
+import (
+	"net/http"
+
+	"github.com/betelgeuse-7/qa/service/badges"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListBadges(c *gin.Context) {
+	res, err := h.badgeRepo.GetBadges()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ListBadges: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"badges": res})
+}
+
+// the post is created, or the vote is cast already. a failure to award a badge doesn't fail the
+// request; it is logged, and the badge is awarded on the next event.
+func awardBadges(h *Handler, ev badges.Event) []string {
+	awarded, err := h.badges.Evaluate(ev)
+	if err != nil {
+		h.logger.Error("httphandlers.awardBadges: %s: %s\n", ev.Kind, err.Error())
+	}
+	return awarded
+}
@@ -0,0 +1,248 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) NewQuestionComment(c *gin.Context) {
+	newComment(h, c, models.COMMENT_TO_QUESTION)
+}
+
+func (h *Handler) NewAnswerComment(c *gin.Context) {
+	newComment(h, c, models.COMMENT_TO_ANSWER)
+}
+
+func (h *Handler) ListQuestionComments(c *gin.Context) {
+	listComments(h, c, models.COMMENT_TO_QUESTION)
+}
+
+func (h *Handler) ListAnswerComments(c *gin.Context) {
+	listComments(h, c, models.COMMENT_TO_ANSWER)
+}
+
+func (h *Handler) UpdateQuestionComment(c *gin.Context) {
+	updateComment(h, c, models.COMMENT_TO_QUESTION)
+}
+
+func (h *Handler) UpdateAnswerComment(c *gin.Context) {
+	updateComment(h, c, models.COMMENT_TO_ANSWER)
+}
+
+func (h *Handler) DeleteQuestionComment(c *gin.Context) {
+	deleteComment(h, c, models.COMMENT_TO_QUESTION)
+}
+
+func (h *Handler) DeleteAnswerComment(c *gin.Context) {
+	deleteComment(h, c, models.COMMENT_TO_ANSWER)
+}
+
+// 'id' parameter is the id of the question, or the answer
+func newComment(h *Handler, c *gin.Context, kind string) {
+	parentId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+	locked, err := checkCommentParentExists(h, c, kind, parentId)
+	if err != nil {
+		return
+	}
+	if locked && !hasRole(c, models.ROLE_MODERATOR) {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ERROR_QUESTION_LOCKED})
+		return
+	}
+	ncp := models.NewCommentPayload{}
+	if err := c.BindJSON(&ncp); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.newComment: bind json: %s\n", err.Error())
+		return
+	}
+	validationErrs, err := ncp.Validate()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.newComment: validate: %s\n", err.Error())
+		return
+	}
+	if len(validationErrs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrs})
+		return
+	}
+	if err := checkLinkPrivilege(h, c, ncp.Text); err != nil {
+		return
+	}
+	ncp.ParentId = parentId
+	ncp.CommentBy = userId
+	res, err := h.commentRepo.NewComment(kind, ncp)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.newComment: %s: %s\n", kind, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "commented successfully", "comment": res})
+}
+
+// 'id' parameter is the id of the question, or the answer
+func listComments(h *Handler, c *gin.Context, kind string) {
+	parentId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if _, err := checkCommentParentExists(h, c, kind, parentId); err != nil {
+		return
+	}
+	comments, err := h.commentRepo.GetComments(kind, parentId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.listComments: %s: %s\n", kind, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"comments": comments})
+}
+
+// 'id' parameter is the id of the comment
+func updateComment(h *Handler, c *gin.Context, kind string) {
+	commentId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserCanModifyComment(h, c, kind, commentId); err != nil {
+		return
+	}
+	ucp := models.UpdateCommentPayload{}
+	if err := c.BindJSON(&ucp); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.updateComment: bind json: %s\n", err.Error())
+		return
+	}
+	validationErrs, err := ucp.Validate()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.updateComment: validate: %s\n", err.Error())
+		return
+	}
+	if len(validationErrs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrs})
+		return
+	}
+	if err := checkLinkPrivilege(h, c, ucp.Text); err != nil {
+		return
+	}
+	res, err := h.commentRepo.UpdateComment(kind, commentId, ucp)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such comment"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.updateComment: %s: %s\n", kind, err.Error())
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "updated comment", "comment": res})
+}
+
+// 'id' parameter is the id of the comment
+func deleteComment(h *Handler, c *gin.Context, kind string) {
+	commentId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserCanModifyComment(h, c, kind, commentId); err != nil {
+		return
+	}
+	if err := h.commentRepo.DeleteComment(kind, commentId); err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.deleteComment: %s: %s\n", kind, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("deleted comment with id '%d'", commentId)})
+}
+
+// the question, or the answer must exist, and must not be deleted. returns whether the question
+// (of the answer) is locked.
+func checkCommentParentExists(h *Handler, c *gin.Context, kind string, parentId int64) (bool, error) {
+	var deletedAt, lockedAt *time.Time
+	var err error
+	switch kind {
+	case models.COMMENT_TO_QUESTION:
+		var qs models.QuestionStatus
+		qs, err = h.questionRepo.GetQuestionStatus(parentId)
+		deletedAt, lockedAt = qs.DeletedAt, qs.LockedAt
+	case models.COMMENT_TO_ANSWER:
+		var as models.AnswerStatus
+		as, err = h.answerRepo.GetAnswerStatus(parentId)
+		deletedAt, lockedAt = as.DeletedAt, as.QuestionLockedAt
+	default:
+		c.Status(http.StatusInternalServerError)
+		return false, fmt.Errorf("httphandlers.checkCommentParentExists: invalid comment kind '%s'", kind)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such " + kind})
+			return false, err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.checkCommentParentExists: %s: %s\n", kind, err.Error())
+		return false, err
+	}
+	if deletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such " + kind})
+		return false, fmt.Errorf("err")
+	}
+	return lockedAt != nil, nil
+}
+
+// the author, or a moderator
+func checkUserCanModifyComment(h *Handler, c *gin.Context, kind string, commentId int64) error {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return fmt.Errorf("err")
+	}
+	cs, err := h.commentRepo.GetCommentStatus(kind, commentId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such comment"})
+			return err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.checkUserCanModifyComment: GetCommentStatus: %s\n", err.Error())
+		return err
+	}
+	if userId != cs.AuthorId && !hasRole(c, models.ROLE_MODERATOR) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return fmt.Errorf("err")
+	}
+	// comment's deleted_at column is set, which means, this comment is deleted.
+	if cs.DeletedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such comment"})
+		return fmt.Errorf("err")
+	}
+	// comments of locked questions can't be changed either, like they can't be added
+	locked, err := checkCommentParentExists(h, c, kind, cs.ParentId)
+	if err != nil {
+		return err
+	}
+	if locked && !hasRole(c, models.ROLE_MODERATOR) {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ERROR_QUESTION_LOCKED})
+		return fmt.Errorf("err")
+	}
+	return nil
+}
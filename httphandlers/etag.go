@@ -0,0 +1,36 @@
+package httphandlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the ETag of a question, or an answer is its quoted version
+func setETag(c *gin.Context, version int64) {
+	c.Header("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// returns the version in the If-Match header, which updates of posts require. If-Match uses the
+// strong comparison, so a weak tag never matches, and the response is 412. '*' only says that
+// the post exists, not which version was read, so it's 428 like a missing header.
+func getIfMatchVersion(c *gin.Context) (int64, error) {
+	ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "missing If-Match header. need the ETag of the post"})
+		return -1, fmt.Errorf("err")
+	}
+	if strings.HasPrefix(ifMatch, "W/") {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "weak ETags don't match. need the ETag of the post"})
+		return -1, fmt.Errorf("err")
+	}
+	version, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+	if err != nil || version <= 0 || len(ifMatch) < 2 || ifMatch[0] != '"' || ifMatch[len(ifMatch)-1] != '"' {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header. need a single ETag"})
+		return -1, fmt.Errorf("err")
+	}
+	return version, nil
+}
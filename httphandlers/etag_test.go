@@ -0,0 +1,50 @@
+package httphandlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetIfMatchVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		ifMatch string
+		code    int
+		version int64
+	}{
+		{`"3"`, http.StatusOK, 3},
+		{` "3" `, http.StatusOK, 3},
+		{"", http.StatusPreconditionRequired, 0},
+		{"*", http.StatusPreconditionRequired, 0},
+		{`W/"3"`, http.StatusPreconditionFailed, 0},
+		{"3", http.StatusBadRequest, 0},
+		{`"0"`, http.StatusBadRequest, 0},
+		{`"3", "4"`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ifMatch, func(t *testing.T) {
+			var version int64
+			r := gin.New()
+			r.PUT("/", func(c *gin.Context) {
+				v, err := getIfMatchVersion(c)
+				if err != nil {
+					return
+				}
+				version = v
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/", nil)
+			if len(tt.ifMatch) > 0 {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			r.ServeHTTP(w, req)
+			if w.Code != tt.code || version != tt.version {
+				t.Fatalf("expected %d, and version %d, got %d, and version %d", tt.code, tt.version, w.Code, version)
+			}
+		})
+	}
+}
@@ -1,13 +1,19 @@
 package httphandlers
 
 import (
+	"context"
 	"log"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/badges"
 	"github.com/betelgeuse-7/qa/service/jwtauth"
 	"github.com/betelgeuse-7/qa/service/logger"
+	"github.com/betelgeuse-7/qa/service/privileges"
+	"github.com/betelgeuse-7/qa/service/purge"
 	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/betelgeuse-7/qa/storage/migrations"
 	"github.com/betelgeuse-7/qa/storage/models"
 	"github.com/betelgeuse-7/qa/storage/postgres"
 	"github.com/gin-gonic/gin"
@@ -16,23 +22,55 @@ import (
 // *gin.Engine wrapper
 type Engine struct {
 	ginEngine *gin.Engine
+	pg        *postgres.Postgres
+	// background jobs, like the purge
+	jobs sync.WaitGroup
 }
 
 func NewEngine(engine *gin.Engine) *Engine {
 	return &Engine{ginEngine: engine}
 }
 
+// waits for the background jobs, which stop when the context of SetRESTRoutes is done, and closes
+// the database
+func (e *Engine) Close() error {
+	e.jobs.Wait()
+	if e.pg == nil {
+		return nil
+	}
+	return e.pg.Db.Close()
+}
+
 type Handler struct {
 	userRepo             models.UserRepository
 	questionRepo         models.QuestionRepository
 	answerRepo           models.AnswerRepository
+	tagRepo              models.TagRepository
+	commentRepo          models.CommentRepository
+	searchRepo           models.SearchRepository
+	refreshTokenRepo     models.RefreshTokenRepository
+	apiKeyRepo           models.ApiKeyRepository
+	reputationRepo       models.ReputationRepository
+	badgeRepo            models.BadgeRepository
+	revisionRepo         models.RevisionRepository
 	jwtRepo              *jwtauth.TokenRepo
+	privileges           *privileges.Checker
+	badges               *badges.Engine
 	logger               *logger.Logger
 	domain, atCookieName string
+	rtCookieName         string
+	csrfCookieName       string
 	useHTTPS             bool
+	// how long authors can restore what they deleted
+	graceWindow time.Duration
+	// for readiness checks
+	pg             *postgres.Postgres
+	migrator       *migrations.Migrator
+	configLoadedAt time.Time
 }
 
-func (e *Engine) SetRESTRoutes(relationalDbConf *config.ConfigRelationalDB, jwtConf *config.ConfigJwt, useHTTPS bool) error {
+// background jobs run until ctx is done. see Close.
+func (e *Engine) SetRESTRoutes(ctx context.Context, relationalDbConf *config.ConfigRelationalDB, jwtConf *config.ConfigJwt, privilegesConf *config.ConfigPrivileges, retentionConf *config.ConfigRetention, httpServerConf *config.ConfigHttpServer) error {
 	r := e.ginEngine
 	v1 := r.Group("api/v1")
 	pg, err := postgres.New(relationalDbConf)
@@ -43,51 +81,165 @@ func (e *Engine) SetRESTRoutes(relationalDbConf *config.ConfigRelationalDB, jwtC
 	if err != nil {
 		return err
 	}
+	e.pg = pg
 	// DI
 	sqlbuilder := sqlbuild.New()
 	userRepo := models.NewUserRepo(pg.Db, sqlbuilder)
 	questionRepo := models.NewQuestionRepo(pg.Db, sqlbuilder)
 	answerRepo := models.NewAnswerRepo(pg.Db, sqlbuilder)
-	jwtRepo := jwtauth.NewTokenRepo(jwtConf)
-	logger := logger.NewLogger(log.Default())
-	domain := os.Getenv("DOMAIN")
-	if domain == "" {
-		domain = "127.0.0.1"
-		log.Println("[INFO] Server domain is not set. Set to '127.0.0.1' by default")
+	tagRepo := models.NewTagRepo(pg.Db, sqlbuilder)
+	commentRepo := models.NewCommentRepo(pg.Db, sqlbuilder)
+	searchRepo := models.NewSearchRepo(pg.Db, sqlbuilder)
+	refreshTokenRepo := models.NewRefreshTokenRepo(pg.Db, sqlbuilder)
+	apiKeyRepo := models.NewApiKeyRepo(pg.Db, sqlbuilder)
+	reputationRepo := models.NewReputationRepo(pg.Db, sqlbuilder)
+	badgeRepo := models.NewBadgeRepo(pg.Db, sqlbuilder)
+	revisionRepo := models.NewRevisionRepo(pg.Db, sqlbuilder)
+	purgeRepo := models.NewPurgeRepo(pg.Db, sqlbuilder)
+	jwtRepo, err := jwtauth.NewTokenRepo(jwtConf)
+	if err != nil {
+		return err
 	}
+	migrator, err := migrations.New(pg.Db)
+	if err != nil {
+		return err
+	}
+	logger := logger.NewLogger(log.Default())
 
 	h := &Handler{userRepo: userRepo,
-		questionRepo: questionRepo,
-		jwtRepo:      jwtRepo,
-		answerRepo:   answerRepo,
-		logger:       logger,
-		domain:       domain,
-		atCookieName: "access-token",
-		useHTTPS:     useHTTPS}
+		questionRepo:     questionRepo,
+		jwtRepo:          jwtRepo,
+		privileges:       privileges.NewChecker(privilegesConf),
+		answerRepo:       answerRepo,
+		tagRepo:          tagRepo,
+		commentRepo:      commentRepo,
+		searchRepo:       searchRepo,
+		refreshTokenRepo: refreshTokenRepo,
+		apiKeyRepo:       apiKeyRepo,
+		reputationRepo:   reputationRepo,
+		badgeRepo:        badgeRepo,
+		revisionRepo:     revisionRepo,
+		badges:           badges.NewEngine(badgeRepo),
+		logger:           logger,
+		domain:           httpServerConf.Domain,
+		atCookieName:     "access-token",
+		rtCookieName:     "refresh-token",
+		csrfCookieName:   "csrf-token",
+		useHTTPS:         httpServerConf.UseTLS,
+		graceWindow:      retentionConf.GraceWindow(),
+		pg:               pg,
+		migrator:         migrator,
+		configLoadedAt:   time.Now()}
+	if job := purge.NewJob(purgeRepo, retentionConf, logger); job != nil {
+		e.jobs.Add(1)
+		go func() {
+			defer e.jobs.Done()
+			job.Run(ctx)
+		}()
+	}
+	r.GET("/.well-known/jwks.json", h.JWKS)
+	r.GET("/healthz", h.Healthz)
+	r.GET("/readyz", h.Readyz)
 	v1.POST("/login", h.Login)
+	v1.POST("/token/refresh", h.RefreshToken)
+	v1.POST("/logout", h.Logout)
+	v1.POST("/logout/all", h.AuthTokenMiddleware, h.SessionOnlyMiddleware, h.LogoutEverywhere)
 	v1.Use(h.RequestBodyIsJSON)
+	// requests authenticated with an api key need the scope of the group. see ScopeMiddleware.
+	read := h.ScopeMiddleware(models.SCOPE_READ)
+	v1.GET("/search", h.AuthTokenMiddleware, read, h.Search)
+	v1.GET("/badges", h.AuthTokenMiddleware, read, h.ListBadges)
 	{
 		users := v1.Group("/users")
 		users.POST("/", h.NewUser)
-		users.GET("/:id", h.AuthTokenMiddleware, h.ViewUserProfile)
-		users.DELETE("/:id", h.AuthTokenMiddleware, h.RequestBodyIsJSON, h.DeleteUser)
+		users.POST("/restore", h.RestoreAccount)
+		users.GET("/:id", h.AuthTokenMiddleware, read, h.ViewUserProfile)
+		users.GET("/reputation/:id", h.AuthTokenMiddleware, read, h.ViewReputationHistory)
+		users.DELETE("/:id", h.AuthTokenMiddleware, h.SessionOnlyMiddleware, h.RequestBodyIsJSON, h.DeleteUser)
+	}
+	{
+		apiKeys := v1.Group("/api-keys")
+		apiKeys.Use(h.AuthTokenMiddleware, h.SessionOnlyMiddleware)
+		apiKeys.POST("/", h.NewApiKey)
+		apiKeys.GET("/", h.ListApiKeys)
+		apiKeys.DELETE("/:id", h.RevokeApiKey)
 	}
 	{
 		questions := v1.Group("/questions")
-		questions.Use(h.AuthTokenMiddleware)
-		questions.POST("/", h.AskQuestion)
+		questions.Use(h.AuthTokenMiddleware, read)
+		questions.GET("/", h.ListQuestions)
 		questions.GET("/:id", h.ViewQuestion)
-		questions.GET("/upvote/:id", h.UpvoteQuestion)
-		questions.GET("/downvote/:id", h.DownvoteQuestion)
+		questions.GET("/comments/:id", h.ListQuestionComments)
+		questions.GET("/:id/revisions", h.ListQuestionRevisions)
+	}
+	{
+		questions := v1.Group("/questions")
+		questions.Use(h.AuthTokenMiddleware, h.ScopeMiddleware(models.SCOPE_QUESTIONS_WRITE))
+		questions.POST("/", h.AskQuestion)
 		questions.PUT("/:id", h.UpdateQuestion)
 		questions.DELETE("/:id", h.DeleteQuestion)
-		questions.POST("/answer/:id", h.NewAnswer)
+		questions.PUT("/accepted-answer/:id", h.AcceptAnswer)
+		questions.DELETE("/accepted-answer/:id", h.UnacceptAnswer)
+		questions.POST("/:id/revisions/:revision/rollback", h.RollbackQuestion)
+		questions.PUT("/restore/:id", h.RestoreQuestion)
 	}
 	{
 		answers := v1.Group("/answers")
-		answers.Use(h.AuthTokenMiddleware)
-		answers.PUT("/:id", h.UpdateAnswer)
-		answers.DELETE("/:id", h.DeleteAnswer)
+		answers.Use(h.AuthTokenMiddleware, read)
+		answers.GET("/comments/:id", h.ListAnswerComments)
+		answers.GET("/:id", h.ViewAnswer)
+		answers.GET("/:id/revisions", h.ListAnswerRevisions)
+	}
+	{
+		answers := v1.Group("/")
+		answers.Use(h.AuthTokenMiddleware, h.ScopeMiddleware(models.SCOPE_ANSWERS_WRITE))
+		answers.POST("/questions/answer/:id", h.NewAnswer)
+		answers.PUT("/answers/:id", h.UpdateAnswer)
+		answers.DELETE("/answers/:id", h.DeleteAnswer)
+		answers.POST("/answers/:id/revisions/:revision/rollback", h.RollbackAnswer)
+		answers.PUT("/answers/restore/:id", h.RestoreAnswer)
+	}
+	{
+		votes := v1.Group("/")
+		votes.Use(h.AuthTokenMiddleware, h.ScopeMiddleware(models.SCOPE_VOTES_WRITE))
+		votes.POST("/questions/upvote/:id", h.UpvoteQuestion)
+		votes.POST("/questions/downvote/:id", h.DownvoteQuestion)
+		votes.DELETE("/questions/vote/:id", h.RetractQuestionVote)
+		votes.POST("/answers/upvote/:id", h.UpvoteAnswer)
+		votes.POST("/answers/downvote/:id", h.DownvoteAnswer)
+		votes.DELETE("/answers/vote/:id", h.RetractAnswerVote)
+	}
+	{
+		comments := v1.Group("/")
+		comments.Use(h.AuthTokenMiddleware, h.ScopeMiddleware(models.SCOPE_COMMENTS_WRITE))
+		comments.POST("/questions/comment/:id", h.NewQuestionComment)
+		comments.POST("/answers/comment/:id", h.NewAnswerComment)
+		comments.PUT("/comments/question/:id", h.UpdateQuestionComment)
+		comments.DELETE("/comments/question/:id", h.DeleteQuestionComment)
+		comments.PUT("/comments/answer/:id", h.UpdateAnswerComment)
+		comments.DELETE("/comments/answer/:id", h.DeleteAnswerComment)
+	}
+	{
+		moderators := v1.Group("/")
+		moderators.Use(h.AuthTokenMiddleware, h.RoleMiddleware(models.ROLE_MODERATOR))
+		questions := moderators.Group("/", h.ScopeMiddleware(models.SCOPE_QUESTIONS_WRITE))
+		questions.PUT("/questions/lock/:id", h.LockQuestion)
+		questions.DELETE("/questions/lock/:id", h.UnlockQuestion)
+		comments := moderators.Group("/", h.ScopeMiddleware(models.SCOPE_COMMENTS_WRITE))
+		comments.PUT("/comments/question/restore/:id", h.RestoreQuestionComment)
+		comments.PUT("/comments/answer/restore/:id", h.RestoreAnswerComment)
+		moderators.PUT("/users/restore/:id", h.SessionOnlyMiddleware, h.RestoreUser)
+	}
+	{
+		admins := v1.Group("/")
+		admins.Use(h.AuthTokenMiddleware, h.SessionOnlyMiddleware, h.RoleMiddleware(models.ROLE_ADMIN))
+		admins.PUT("/users/role/:id", h.SetUserRole)
+	}
+	{
+		tags := v1.Group("/tags")
+		tags.Use(h.AuthTokenMiddleware, read)
+		tags.GET("/", h.ListTags)
+		tags.GET("/:tag/questions", h.ListQuestionsByTag)
 	}
 	return nil
 }
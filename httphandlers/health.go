@@ -0,0 +1,127 @@
+package httphandlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// a check that takes longer fails
+	_CHECK_TIMEOUT = time.Second * 2
+	// a check that passes, but takes longer is degraded
+	_CHECK_SLOW = time.Millisecond * 500
+)
+
+const (
+	HEALTH_OK       = "ok"
+	HEALTH_DEGRADED = "degraded"
+	HEALTH_FAIL     = "fail"
+)
+
+type checkResult struct {
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// returns a detail for the response. errors are in the response too, so they shouldn't say more
+// than an operator needs.
+type check func(h *Handler, ctx context.Context) (string, error)
+
+var readinessChecks = map[string]check{
+	"postgres":   checkPostgres,
+	"migrations": checkMigrations,
+	"config":     checkConfig,
+}
+
+// liveness. the process is up, and serving.
+func (h *Handler) Healthz(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"status": HEALTH_OK})
+}
+
+// readiness. 503 if a check fails, so that the orchestrator stops sending traffic. a slow check
+// is degraded, but ready.
+func (h *Handler) Readyz(c *gin.Context) {
+	results := map[string]checkResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, fn := range readinessChecks {
+		wg.Add(1)
+		go func(name string, fn check) {
+			defer wg.Done()
+			res := runCheck(h, c.Request.Context(), name, fn)
+			mu.Lock()
+			results[name] = res
+			mu.Unlock()
+		}(name, fn)
+	}
+	wg.Wait()
+	status := HEALTH_OK
+	for _, r := range results {
+		if r.Status == HEALTH_FAIL || (r.Status == HEALTH_DEGRADED && status == HEALTH_OK) {
+			status = r.Status
+		}
+	}
+	code := http.StatusOK
+	if status == HEALTH_FAIL {
+		code = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(code, gin.H{"status": status, "checks": results})
+}
+
+func runCheck(h *Handler, parent context.Context, name string, fn check) checkResult {
+	ctx, cancel := context.WithTimeout(parent, _CHECK_TIMEOUT)
+	defer cancel()
+	start := time.Now()
+	detail, err := fn(h, ctx)
+	took := time.Since(start)
+	res := checkResult{Status: HEALTH_OK, DurationMs: float64(took.Microseconds()) / 1000, Detail: detail}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		res.Status, res.Error = HEALTH_FAIL, fmt.Sprintf("timed out after %s", _CHECK_TIMEOUT)
+	case err != nil:
+		res.Status, res.Error = HEALTH_FAIL, err.Error()
+	case took > _CHECK_SLOW:
+		res.Status = HEALTH_DEGRADED
+	}
+	if res.Status != HEALTH_OK {
+		h.logger.Info("readiness: %s is %s (%s): %s\n", name, res.Status, took, res.Error)
+	}
+	return res
+}
+
+func checkPostgres(h *Handler, ctx context.Context) (string, error) {
+	if err := h.pg.Db.PingContext(ctx); err != nil {
+		h.logger.Error("checkPostgres: %s\n", err.Error())
+		return "", fmt.Errorf("ping failed")
+	}
+	return "", nil
+}
+
+// the database is at the latest version this binary knows
+func checkMigrations(h *Handler, ctx context.Context) (string, error) {
+	pending, err := h.migrator.Pending(ctx)
+	if err != nil {
+		h.logger.Error("checkMigrations: %s\n", err.Error())
+		return "", fmt.Errorf("couldn't read the applied migrations")
+	}
+	detail := fmt.Sprintf("expected version %d", h.migrator.Latest())
+	if len(pending) > 0 {
+		return detail, fmt.Errorf("%d pending migrations. run 'migrate up'", len(pending))
+	}
+	return detail, nil
+}
+
+// the server doesn't start without a valid config, so this only reports when it was loaded
+func checkConfig(h *Handler, ctx context.Context) (string, error) {
+	return "loaded at " + h.configLoadedAt.Format(time.RFC3339), nil
+}
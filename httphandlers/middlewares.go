@@ -1,24 +1,52 @@
 package httphandlers
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+	"github.com/betelgeuse-7/qa/storage/models"
 	"github.com/gin-gonic/gin"
 )
 
-const ContextUserIdKey = "user"
+const (
+	ContextUserIdKey = "user"
+	// one of models.ROLE_*
+	ContextUserRoleKey = "role"
+	// []string. only set for requests that are authenticated with an api key.
+	ContextApiKeyScopesKey = "api_key_scopes"
+)
 
+// the access token is either in the 'Authorization: Bearer <jwt>' header, or in the access
+// token cookie. the header wins if both are present.
+//
+// the header can also have an api key ('Authorization: Bearer qa_...') instead of an access
+// token. see ScopeMiddleware.
 func (h *Handler) AuthTokenMiddleware(c *gin.Context) {
-	at, err := c.Cookie(h.atCookieName)
-	if err != nil {
-		if err == http.ErrNoCookie {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing access token cookie"})
+	at, ok := bearerToken(c)
+	if ok && strings.HasPrefix(at, models.API_KEY_PREFIX) {
+		authenticateApiKey(h, c, at)
+		return
+	}
+	if !ok {
+		var err error
+		at, err = c.Cookie(h.atCookieName)
+		if err != nil {
+			if err == http.ErrNoCookie {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing access token cookie, or authorization header"})
+				return
+			}
+			errStr := err.Error()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errStr})
+			return
+		}
+		// browsers send cookies with cross-site requests too
+		if !checkCSRFToken(h, c) {
 			return
 		}
-		errStr := err.Error()
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errStr})
-		return
 	}
 	atTok, atClaims, err := h.jwtRepo.ParseToken(at)
 	if err != nil {
@@ -33,10 +61,119 @@ func (h *Handler) AuthTokenMiddleware(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
 		return
 	}
+	if !models.IsValidRole(atClaims.Role) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+		return
+	}
 	c.Set(ContextUserIdKey, atClaimsUserId)
+	c.Set(ContextUserRoleKey, atClaims.Role)
+	c.Next()
+}
+
+func authenticateApiKey(h *Handler, c *gin.Context, key string) {
+	auth, err := h.apiKeyRepo.GetApiKeyAuth(jwtauth.HashApiKey(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		h.logger.Error("authenticateApiKey: get api key auth: %s\n", err.Error())
+		return
+	}
+	c.Set(ContextUserIdKey, auth.UserId)
+	c.Set(ContextUserRoleKey, auth.Role)
+	c.Set(ContextApiKeyScopesKey, []string(auth.Scopes))
+	c.Next()
+}
+
+// the user must have the role, or a role above it. use after AuthTokenMiddleware.
+func (h *Handler) RoleMiddleware(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasRole(c, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "required_role": role})
+			return
+		}
+		c.Next()
+	}
+}
+
+// whether the user of the request has the role, or a role above it
+func hasRole(c *gin.Context, role string) bool {
+	return models.RoleAtLeast(c.GetString(ContextUserRoleKey), role)
+}
+
+// requests that are authenticated with an api key need the scope. requests that are
+// authenticated with an access token can do everything. use after AuthTokenMiddleware.
+func (h *Handler) ScopeMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		v, ok := c.Get(ContextApiKeyScopesKey)
+		if !ok {
+			c.Next()
+			return
+		}
+		scopes, _ := v.([]string)
+		for _, s := range scopes {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "api key is missing the scope", "scope": scope})
+	}
+}
+
+// rejects requests that are authenticated with an api key. an api key can't manage api keys,
+// or the account. use after AuthTokenMiddleware.
+func (h *Handler) SessionOnlyMiddleware(c *gin.Context) {
+	if _, ok := c.Get(ContextApiKeyScopesKey); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "api keys can't be used here. log in"})
+		return
+	}
 	c.Next()
 }
 
+// returns the token, and whether the Authorization header is present
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if len(header) == 0 {
+		return "", false
+	}
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || strings.ToLower(header[:len(prefix)]) != prefix {
+		// present, but malformed. an empty token fails to parse.
+		return "", true
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+// double-submit CSRF protection, for requests that are authenticated with cookies. the
+// csrf token cookie is readable by the scripts of our own origin only, and they send it
+// back in the X-CSRF-Token header. a cross-site form can't set the header.
+//
+// safe methods (GET, HEAD, OPTIONS) don't change state, and are not checked. so a route that
+// changes state, like a vote, must never be a GET.
+//
+// aborts the request with 403, and returns false if the check fails.
+func checkCSRFToken(h *Handler, c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if !validCSRFToken(h, c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "missing, or invalid csrf token"})
+		return false
+	}
+	return true
+}
+
+// the csrf token cookie, and header match
+func validCSRFToken(h *Handler, c *gin.Context) bool {
+	cookie, err := c.Cookie(h.csrfCookieName)
+	header := c.GetHeader(_CSRF_HEADER)
+	return err == nil && len(cookie) > 0 && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
 func (h *Handler) RequestBodyIsJSON(c *gin.Context) {
 	if c.Request.Method == "PUT" || c.Request.Method == "PATCH" || c.Request.Method == "POST" {
 		appJson := "application/json"
@@ -0,0 +1,86 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+	"github.com/betelgeuse-7/qa/service/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+func TestAuthTokenMiddlewareRejectsHostileTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := []byte("test-secret-test-secret-test-secret")
+	tr, err := jwtauth.NewTokenRepo(&config.ConfigJwt{
+		Keys:      []config.ConfigJwtKey{{Kid: "k1", Secret: secret}},
+		ActiveKid: "k1",
+		Issuer:    "qa-api",
+		Audience:  "qa-api",
+		Algorithm: "HS256",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{jwtRepo: tr, logger: logger.NewLogger(log.New(io.Discard, "", 0)), atCookieName: "access-token"}
+	r := gin.New()
+	r.GET("/", h.AuthTokenMiddleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user": c.GetInt64(ContextUserIdKey)})
+	})
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"exp": now.Add(10 * time.Minute).Unix(), "iat": now.Unix(), "iss": "qa-api", "aud": "qa-api",
+		"user_id": 1, "role": "user",
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(method, claims)
+		tok.Header["kid"] = "k1"
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	wrongAud := jwt.MapClaims{}
+	for k, v := range claims {
+		wrongAud[k] = v
+	}
+	wrongAud["aud"] = "other-service"
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid", sign(jwt.SigningMethodHS256, secret, claims), http.StatusOK},
+		{"alg none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims), http.StatusUnauthorized},
+		{"HS384", sign(jwt.SigningMethodHS384, secret, claims), http.StatusUnauthorized},
+		{"wrong aud", sign(jwt.SigningMethodHS256, secret, wrongAud), http.StatusUnauthorized},
+		{"malformed", "not.a.token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			r.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Fatalf("expected %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+			if tt.code != http.StatusUnauthorized {
+				return
+			}
+			// no details of why the token is rejected
+			body := map[string]string{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["error"] != "invalid access token" {
+				t.Fatalf("unexpected body: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// handlers in this file are behind RoleMiddleware. see SetRESTRoutes. restoring questions, answers,
+// and users is in restore.go; their authors can restore them too.
+
+func (h *Handler) LockQuestion(c *gin.Context) {
+	userId := c.GetInt64(ContextUserIdKey)
+	setQuestionLock(h, c, &userId)
+}
+
+func (h *Handler) UnlockQuestion(c *gin.Context) {
+	setQuestionLock(h, c, nil)
+}
+
+// a nil lockedBy unlocks the question
+func setQuestionLock(h *Handler, c *gin.Context, lockedBy *int64) {
+	questionId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := h.questionRepo.SetQuestionLock(questionId, lockedBy); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.setQuestionLock: %s\n", err.Error())
+		return
+	}
+	if lockedBy == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "unlocked question"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "locked question"})
+}
+
+func (h *Handler) RestoreQuestionComment(c *gin.Context) {
+	restoreComment(h, c, models.COMMENT_TO_QUESTION)
+}
+
+func (h *Handler) RestoreAnswerComment(c *gin.Context) {
+	restoreComment(h, c, models.COMMENT_TO_ANSWER)
+}
+
+// 'id' parameter is the id of the comment
+func restoreComment(h *Handler, c *gin.Context, kind string) {
+	commentId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := h.commentRepo.RestoreComment(kind, commentId); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted comment"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.restoreComment: %s: %s\n", kind, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restored comment"})
+}
+
+type setUserRolePayload struct {
+	Role string `json:"role"`
+}
+
+// admins only. the new role is in the access tokens of the user after their next refresh.
+func (h *Handler) SetUserRole(c *gin.Context) {
+	userId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	// an admin can't lock themselves out, or leave the site without an admin by accident
+	if userId == c.GetInt64(ContextUserIdKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "can't change own role"})
+		return
+	}
+	var payload setUserRolePayload
+	if err := c.BindJSON(&payload); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.SetUserRole: bind json: %s\n", err.Error())
+		return
+	}
+	if !models.IsValidRole(payload.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role. need one of 'user', 'moderator', 'admin'"})
+		return
+	}
+	if err := h.userRepo.SetUserRole(userId, payload.Role); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such user"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.SetUserRole: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "changed role", "role": payload.Role})
+}
@@ -0,0 +1,90 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/betelgeuse-7/qa/service/privileges"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// models.UserStanding of the user of the request. loaded once per request.
+const _CONTEXT_STANDING_KEY = "standing"
+
+func getStanding(h *Handler, c *gin.Context) (models.UserStanding, error) {
+	if v, ok := c.Get(_CONTEXT_STANDING_KEY); ok {
+		return v.(models.UserStanding), nil
+	}
+	standing, err := h.userRepo.GetUserStanding(c.GetInt64(ContextUserIdKey))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+			return standing, err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.getStanding: %s\n", err.Error())
+		return standing, err
+	}
+	c.Set(_CONTEXT_STANDING_KEY, standing)
+	return standing, nil
+}
+
+// moderators have every privilege. the 403 response names the missing privilege, its
+// requirements, and where the user stands.
+func checkPrivilege(h *Handler, c *gin.Context, privilege string) error {
+	if hasRole(c, models.ROLE_MODERATOR) {
+		return nil
+	}
+	us, err := getStanding(h, c)
+	if err != nil {
+		return err
+	}
+	standing := privileges.NewStanding(us.CreatedAt, us.Posts, us.NetUpvotes, time.Now())
+	if h.privileges.Has(privilege, standing) {
+		return nil
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "missing privilege", "privilege": privilege,
+		"requirements": h.privileges.Requirements(privilege), "current": standing})
+	return fmt.Errorf("err")
+}
+
+// only users with the post links privilege can post texts with links in them
+func checkLinkPrivilege(h *Handler, c *gin.Context, texts ...string) error {
+	if !privileges.ContainsLink(texts...) {
+		return nil
+	}
+	return checkPrivilege(h, c, privileges.PRIVILEGE_POST_LINKS)
+}
+
+// users without the unlimited questions privilege can ask a limited number of questions a day.
+// returns the limit of the user, 0 for no limit. NewQuestion enforces it.
+func questionsPerDayLimit(h *Handler, c *gin.Context) (int64, error) {
+	limit := h.privileges.QuestionsPerDay()
+	if limit == 0 || hasRole(c, models.ROLE_MODERATOR) {
+		return 0, nil
+	}
+	us, err := getStanding(h, c)
+	if err != nil {
+		return 0, err
+	}
+	standing := privileges.NewStanding(us.CreatedAt, us.Posts, us.NetUpvotes, time.Now())
+	if h.privileges.Has(privileges.PRIVILEGE_UNLIMITED_QUESTIONS, standing) {
+		return 0, nil
+	}
+	return limit, nil
+}
+
+// for models.ERROR_QUESTIONS_PER_DAY
+func questionsPerDayExceeded(h *Handler, c *gin.Context, limit int64) {
+	us, err := getStanding(h, c)
+	if err != nil {
+		return
+	}
+	standing := privileges.NewStanding(us.CreatedAt, us.Posts, us.NetUpvotes, time.Now())
+	c.JSON(http.StatusForbidden, gin.H{"error": "missing privilege", "privilege": privileges.PRIVILEGE_UNLIMITED_QUESTIONS,
+		"requirements": h.privileges.Requirements(privileges.PRIVILEGE_UNLIMITED_QUESTIONS), "current": standing,
+		"questions_per_day": limit})
+}
@@ -5,11 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/betelgeuse-7/qa/service/badges"
+	"github.com/betelgeuse-7/qa/service/privileges"
 	"github.com/betelgeuse-7/qa/storage/models"
-	"github.com/betelgeuse-7/qa/storage/postgres"
 	"github.com/gin-gonic/gin"
-	"github.com/lib/pq"
 )
 
 func (h *Handler) AskQuestion(c *gin.Context) {
@@ -39,14 +40,27 @@ func (h *Handler) AskQuestion(c *gin.Context) {
 		c.Status(http.StatusUnauthorized)
 		return
 	}
+	dailyLimit, err := questionsPerDayLimit(h, c)
+	if err != nil {
+		return
+	}
+	if err := checkLinkPrivilege(h, c, nqp.Title, nqp.Text); err != nil {
+		return
+	}
 	nqp.UserId = userId
+	nqp.DailyLimit = dailyLimit
 	response, err := h.questionRepo.NewQuestion(nqp)
 	if err != nil {
+		if err.Error() == models.ERROR_QUESTIONS_PER_DAY {
+			questionsPerDayExceeded(h, c, dailyLimit)
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("*Handler.AskQuestion: new question: %s\n", err.Error())
 		return
 	}
-	c.JSON(http.StatusCreated, gin.H{"message": "question added", "question": response})
+	ev := badges.Event{Kind: badges.EVENT_QUESTION_CREATED, ActorId: userId, PostType: "question", PostId: response.QuestionId}
+	c.JSON(http.StatusCreated, gin.H{"message": "question added", "question": response, "badges": awardBadges(h, ev)})
 }
 
 func (h *Handler) ViewQuestion(c *gin.Context) {
@@ -66,17 +80,22 @@ func (h *Handler) ViewQuestion(c *gin.Context) {
 		h.logger.Error("*Handler.ViewQuestion: get question: %s\n", err.Error())
 		return
 	}
+	setETag(c, q.Version)
 	c.JSON(http.StatusOK, q)
 }
 
-// can only update the text or the title
+// can only update the text or the title. moderators can update any question.
 func (h *Handler) UpdateQuestion(c *gin.Context) {
 	var payload *models.UpdateQuestionPayload = &models.UpdateQuestionPayload{}
 	questionId, err := getInt64IdParam(c)
 	if err != nil {
 		return
 	}
-	if err := checkUserIsTheAuthorOfQuestion(h, c, questionId); err != nil {
+	if err := checkUserCanModifyQuestion(h, c, questionId); err != nil {
+		return
+	}
+	version, err := getIfMatchVersion(c)
+	if err != nil {
 		return
 	}
 	err = c.BindJSON(payload)
@@ -99,16 +118,26 @@ func (h *Handler) UpdateQuestion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrs})
 		return
 	}
+	if err := checkLinkPrivilege(h, c, payload.Title, payload.Text); err != nil {
+		return
+	}
+	payload.EditedBy = c.GetInt64(ContextUserIdKey)
+	payload.IfVersion = version
 	res, err := h.questionRepo.UpdateQuestion(questionId, payload)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
 			return
 		}
+		if errMsg := err.Error(); errMsg == models.ERROR_VERSION_MISMATCH {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": errMsg})
+			return
+		}
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("*Handler.UpdateQuestion: UpdateQuestion: %s\n", err.Error())
 		return
 	}
+	setETag(c, res.Version)
 	c.JSON(http.StatusCreated, res)
 }
 
@@ -117,10 +146,10 @@ func (h *Handler) DeleteQuestion(c *gin.Context) {
 	if err != nil {
 		return
 	}
-	if err := checkUserIsTheAuthorOfQuestion(h, c, questionId); err != nil {
+	if err := checkUserCanModifyQuestion(h, c, questionId); err != nil {
 		return
 	}
-	err = h.questionRepo.DeleteQuestion(questionId)
+	err = h.questionRepo.DeleteQuestion(questionId, c.GetInt64(ContextUserIdKey))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
@@ -133,7 +162,81 @@ func (h *Handler) DeleteQuestion(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "deleted question"})
 }
 
+type acceptAnswerPayload struct {
+	AnswerId int64 `json:"answer_id"`
+}
+
+// only the author of the question can accept an answer
+func (h *Handler) AcceptAnswer(c *gin.Context) {
+	questionId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserIsTheAuthorOfQuestion(h, c, questionId); err != nil {
+		return
+	}
+	var payload acceptAnswerPayload
+	if err := c.BindJSON(&payload); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.AcceptAnswer: bind json: %s\n", err.Error())
+		return
+	}
+	if payload.AnswerId <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing answer_id"})
+		return
+	}
+	setAcceptedAnswer(h, c, questionId, &payload.AnswerId)
+}
+
+func (h *Handler) UnacceptAnswer(c *gin.Context) {
+	questionId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserIsTheAuthorOfQuestion(h, c, questionId); err != nil {
+		return
+	}
+	setAcceptedAnswer(h, c, questionId, nil)
+}
+
+func setAcceptedAnswer(h *Handler, c *gin.Context, questionId int64, answerId *int64) {
+	err := h.questionRepo.SetAcceptedAnswer(questionId, answerId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
+			return
+		}
+		if errMsg := err.Error(); errMsg == models.ERROR_ANSWER_NOT_OF_QUESTION {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.setAcceptedAnswer: %s\n", err.Error())
+		return
+	}
+	if answerId == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "unaccepted answer"})
+		return
+	}
+	awardBadges(h, badges.Event{Kind: badges.EVENT_ANSWER_ACCEPTED, ActorId: c.GetInt64(ContextUserIdKey), PostType: "answer", PostId: *answerId})
+	c.JSON(http.StatusOK, gin.H{"message": "accepted answer", "answer_id": *answerId})
+}
+
 func checkUserIsTheAuthorOfQuestion(h *Handler, c *gin.Context, questionId int64) error {
+	return checkQuestionPermission(h, c, questionId, false)
+}
+
+// the author, or a moderator
+func checkUserCanModifyQuestion(h *Handler, c *gin.Context, questionId int64) error {
+	return checkQuestionPermission(h, c, questionId, true)
+}
+
+// only moderators can modify a locked question
+func checkQuestionPermission(h *Handler, c *gin.Context, questionId int64, moderatorsToo bool) error {
 	userId := c.GetInt64(ContextUserIdKey)
 	if userId <= 0 {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
@@ -149,7 +252,8 @@ func checkUserIsTheAuthorOfQuestion(h *Handler, c *gin.Context, questionId int64
 		h.logger.Error("*Handler.UpdateQuestion: GetAuthorIdOfQuestion: %s\n", err.Error())
 		return err
 	}
-	if userId != qs.AuthorId {
+	isModerator := moderatorsToo && hasRole(c, models.ROLE_MODERATOR)
+	if userId != qs.AuthorId && !isModerator {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 		return fmt.Errorf("err")
 	}
@@ -158,6 +262,10 @@ func checkUserIsTheAuthorOfQuestion(h *Handler, c *gin.Context, questionId int64
 		c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
 		return fmt.Errorf("err")
 	}
+	if qs.LockedAt != nil && !isModerator {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ERROR_QUESTION_LOCKED})
+		return fmt.Errorf("err")
+	}
 	return nil
 }
 
@@ -172,20 +280,91 @@ func getInt64IdParam(c *gin.Context) (int64, error) {
 	return questionId, nil
 }
 
+const (
+	_DEFAULT_PAGE_LIMIT = uint64(20)
+	_MAX_PAGE_LIMIT     = uint64(100)
+)
+
+// query parameters are 'limit', and 'offset'
+func getLimitOffsetQuery(c *gin.Context) (uint64, uint64, error) {
+	limit, err := getLimitQuery(c)
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := uint64(0)
+	if offsetStr := c.Query("offset"); len(offsetStr) > 0 {
+		o, err := strconv.ParseUint(offsetStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset. need a non-negative integer"})
+			return 0, 0, fmt.Errorf("err")
+		}
+		offset = o
+	}
+	return limit, offset, nil
+}
+
+// query parameter is 'limit'
+func getLimitQuery(c *gin.Context) (uint64, error) {
+	limit := _DEFAULT_PAGE_LIMIT
+	if limitStr := c.Query("limit"); len(limitStr) > 0 {
+		l, err := strconv.ParseUint(limitStr, 10, 64)
+		if err != nil || l == 0 || l > _MAX_PAGE_LIMIT {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit. need an integer in range [1,%d]", _MAX_PAGE_LIMIT)})
+			return 0, fmt.Errorf("err")
+		}
+		limit = l
+	}
+	return limit, nil
+}
+
+// GET /questions?sort=newest|score|answers|unanswered&tag=&author=&cursor=&limit=
+func (h *Handler) ListQuestions(c *gin.Context) {
+	limit, err := getLimitQuery(c)
+	if err != nil {
+		return
+	}
+	params := models.ListQuestionsParams{
+		Sort:   c.DefaultQuery("sort", models.QUESTIONS_SORT_NEWEST),
+		Tag:    strings.ToLower(strings.TrimSpace(c.Query("tag"))),
+		Author: strings.TrimPrefix(c.Query("author"), "@"),
+		Cursor: c.Query("cursor"),
+		Limit:  limit,
+	}
+	res, err := h.questionRepo.ListQuestions(params)
+	if err != nil {
+		if errMsg := err.Error(); errMsg == models.ERROR_INVALID_SORT || errMsg == models.ERROR_INVALID_CURSOR {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ListQuestions: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
 func (h *Handler) UpvoteQuestion(c *gin.Context) {
-	err := voteQuestion(h, c, "upvote")
+	err := voteQuestion(h, c, models.VOTE_UPVOTE)
 	if err != nil {
 		return
 	}
 }
 
 func (h *Handler) DownvoteQuestion(c *gin.Context) {
-	err := voteQuestion(h, c, "downvote")
+	err := voteQuestion(h, c, models.VOTE_DOWNVOTE)
+	if err != nil {
+		return
+	}
+}
+
+func (h *Handler) RetractQuestionVote(c *gin.Context) {
+	err := voteQuestion(h, c, "retract")
 	if err != nil {
 		return
 	}
 }
 
+// type_ is "upvote", "downvote", or "retract". casting the opposite vote switches the vote.
 func voteQuestion(h *Handler, c *gin.Context, type_ string) error {
 	questionId, err := getInt64IdParam(c)
 	if err != nil {
@@ -197,13 +376,19 @@ func voteQuestion(h *Handler, c *gin.Context, type_ string) error {
 		return fmt.Errorf("err")
 	}
 	ownQuestionErr := ""
+	currentVote := ""
 	switch type_ {
-	case "upvote":
+	case models.VOTE_UPVOTE:
 		ownQuestionErr = models.ERROR_UPVOTE_OWN_QUESTION
-		err = h.questionRepo.UpvoteQuestion(questionId, userId)
-	case "downvote":
+		currentVote, err = h.questionRepo.UpvoteQuestion(questionId, userId)
+	case models.VOTE_DOWNVOTE:
+		if err := checkPrivilege(h, c, privileges.PRIVILEGE_DOWNVOTE); err != nil {
+			return err
+		}
 		ownQuestionErr = models.ERROR_DOWNVOTE_OWN_QUESTION
-		err = h.questionRepo.DownvoteQuestion(questionId, userId)
+		currentVote, err = h.questionRepo.DownvoteQuestion(questionId, userId)
+	case "retract":
+		currentVote, err = h.questionRepo.RetractQuestionVote(questionId, userId)
 	default:
 		return fmt.Errorf("httphandlers.voteQuestion: invalid vote type '%s'", type_)
 	}
@@ -212,18 +397,25 @@ func voteQuestion(h *Handler, c *gin.Context, type_ string) error {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "no such question"})
 			return err
 		}
-		if err, ok := err.(*pq.Error); ok && err.Code == postgres.ERROR_UNIQUE_VIOLATION {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "already " + type_ + "d"})
+		if errMsg := err.Error(); len(ownQuestionErr) > 0 && errMsg == ownQuestionErr {
+			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
 			return err
 		}
-		if errMsg := err.Error(); errMsg == ownQuestionErr {
-			c.JSON(http.StatusBadRequest, gin.H{"error": errMsg})
+		if errMsg := err.Error(); errMsg == models.ERROR_QUESTION_LOCKED {
+			c.JSON(http.StatusForbidden, gin.H{"error": errMsg})
 			return err
 		}
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("httphandlers.voteQuestion: %s: %s\n", type_, err.Error())
 		return err
 	}
-	c.JSON(http.StatusCreated, gin.H{"message": type_ + "d question"})
+	if type_ == "retract" {
+		c.JSON(http.StatusOK, gin.H{"message": "retracted vote", "vote": currentVote})
+		return nil
+	}
+	if currentVote == models.VOTE_UPVOTE {
+		awardBadges(h, badges.Event{Kind: badges.EVENT_QUESTION_UPVOTED, ActorId: userId, PostType: "question", PostId: questionId})
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": type_ + "d question", "vote": currentVote})
 	return nil
 }
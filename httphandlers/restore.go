@@ -0,0 +1,167 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/betelgeuse-7/qa/service/hashpwd"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// moderators can restore anytime before the purge. authors can restore the posts they deleted
+// themselves, within the grace window.
+
+func (h *Handler) RestoreQuestion(c *gin.Context) {
+	questionId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	qs, err := h.questionRepo.GetQuestionStatus(questionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted question"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreQuestion: get question status: %s\n", err.Error())
+		return
+	}
+	if err := checkUserCanRestore(h, c, "question", qs.AuthorId, qs.DeletedAt, qs.DeletedBy); err != nil {
+		return
+	}
+	if err := h.questionRepo.RestoreQuestion(questionId); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted question"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreQuestion: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restored question"})
+}
+
+func (h *Handler) RestoreAnswer(c *gin.Context) {
+	answerId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	as, err := h.answerRepo.GetAnswerStatus(answerId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted answer"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAnswer: get answer status: %s\n", err.Error())
+		return
+	}
+	if err := checkUserCanRestore(h, c, "answer", as.UserId, as.DeletedAt, as.DeletedBy); err != nil {
+		return
+	}
+	if err := h.answerRepo.RestoreAnswer(answerId); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted answer"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAnswer: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restored answer"})
+}
+
+func checkUserCanRestore(h *Handler, c *gin.Context, post string, authorId int64, deletedAt *time.Time, deletedBy *int64) error {
+	if deletedAt == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted " + post})
+		return fmt.Errorf("err")
+	}
+	if hasRole(c, models.ROLE_MODERATOR) {
+		return nil
+	}
+	userId := c.GetInt64(ContextUserIdKey)
+	// posts deleted before deleted_by existed have no deleter. only moderators can restore them.
+	if authorId != userId || deletedBy == nil || *deletedBy != userId {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return fmt.Errorf("err")
+	}
+	if time.Since(*deletedAt) > h.graceWindow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the grace window to restore the " + post + " is over. ask a moderator"})
+		return fmt.Errorf("err")
+	}
+	return nil
+}
+
+// moderators only. see RestoreAccount for users restoring their own accounts.
+func (h *Handler) RestoreUser(c *gin.Context) {
+	userId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := h.userRepo.RestoreUser(userId); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such deleted user"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreUser: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restored user"})
+}
+
+// a deleted user can't log in, so they restore their account with their email, and password,
+// within the grace window. then they log in as usual.
+func (h *Handler) RestoreAccount(c *gin.Context) {
+	ulp := &models.UserLoginPayload{}
+	if err := c.BindJSON(ulp); err != nil {
+		if err.Error() == "EOF" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no json body"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAccount: bind json: %s\n", err.Error())
+		return
+	}
+	validationErrs, err := ulp.Validate()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAccount: validate: %s\n", err.Error())
+		return
+	}
+	if len(validationErrs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrs})
+		return
+	}
+	du, err := h.userRepo.GetDeletedUser(ulp.Email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "no such deleted user"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAccount: %s\n", err.Error())
+		return
+	}
+	if err := hashpwd.CompareHashAndPwd(du.Pwd, ulp.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong password"})
+		return
+	}
+	if time.Since(du.DeletedAt) > h.graceWindow {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the grace window to restore the account is over. ask a moderator"})
+		return
+	}
+	if err := h.userRepo.RestoreUser(du.UserId); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "no such deleted user"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RestoreAccount: restore user: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "restored account. log in to continue"})
+}
@@ -0,0 +1,181 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/betelgeuse-7/qa/service/diff"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// context lines around the changes in revision diffs
+const _DIFF_CONTEXT = 3
+
+func (h *Handler) ListQuestionRevisions(c *gin.Context) {
+	listRevisions(h, c, "question")
+}
+
+func (h *Handler) ListAnswerRevisions(c *gin.Context) {
+	listRevisions(h, c, "answer")
+}
+
+// 'id' parameter is the id of the question, or the answer. a post that was never edited has no
+// revisions.
+func listRevisions(h *Handler, c *gin.Context, post string) {
+	postId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkPostExists(h, c, post, postId); err != nil {
+		return
+	}
+	revisions, err := h.revisionRepo.GetRevisions(post, postId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.listRevisions: %s: %s\n", post, err.Error())
+		return
+	}
+	for i := 1; i < len(revisions); i++ {
+		revisions[i].Diff = revisionDiff(revisions[i-1], revisions[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+// title, and text are diffed separately
+func revisionDiff(from, to models.RevisionResponse) string {
+	fromName := fmt.Sprintf("revision %d", from.Revision)
+	toName := fmt.Sprintf("revision %d", to.Revision)
+	res := ""
+	if from.Title != nil && to.Title != nil {
+		res = diff.Unified(fromName+"/title", toName+"/title", *from.Title, *to.Title, _DIFF_CONTEXT)
+	}
+	return res + diff.Unified(fromName+"/text", toName+"/text", from.Text, to.Text, _DIFF_CONTEXT)
+}
+
+func (h *Handler) RollbackQuestion(c *gin.Context) {
+	questionId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserCanModifyQuestion(h, c, questionId); err != nil {
+		return
+	}
+	// a rollback replaces the current content, so it can overwrite a concurrent edit like an update
+	version, err := getIfMatchVersion(c)
+	if err != nil {
+		return
+	}
+	revision, err := getRevision(h, c, "question", questionId)
+	if err != nil {
+		return
+	}
+	// the tags are not versioned, and are left as they are
+	payload := &models.UpdateQuestionPayload{Text: revision.Text,
+		Summary:  fmt.Sprintf("rollback to revision %d", revision.Revision),
+		EditedBy: c.GetInt64(ContextUserIdKey), IfVersion: version}
+	if revision.Title != nil {
+		payload.Title = *revision.Title
+	}
+	res, err := h.questionRepo.UpdateQuestion(questionId, payload)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such question"})
+			return
+		}
+		if errMsg := err.Error(); errMsg == models.ERROR_VERSION_MISMATCH {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": errMsg})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RollbackQuestion: UpdateQuestion: %s\n", err.Error())
+		return
+	}
+	setETag(c, res.Version)
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) RollbackAnswer(c *gin.Context) {
+	answerId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	if err := checkUserCanModifyAnswer(h, c, answerId); err != nil {
+		return
+	}
+	version, err := getIfMatchVersion(c)
+	if err != nil {
+		return
+	}
+	revision, err := getRevision(h, c, "answer", answerId)
+	if err != nil {
+		return
+	}
+	uap := models.UpdateAnswerPayload{Text: revision.Text,
+		Summary:  fmt.Sprintf("rollback to revision %d", revision.Revision),
+		EditedBy: c.GetInt64(ContextUserIdKey), IfVersion: version}
+	uar, err := h.answerRepo.UpdateAnswer(uap, answerId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no such answer"})
+			return
+		}
+		if errMsg := err.Error(); errMsg == models.ERROR_VERSION_MISMATCH {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": errMsg})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RollbackAnswer: UpdateAnswer: %s\n", err.Error())
+		return
+	}
+	setETag(c, uar.Version)
+	c.JSON(http.StatusCreated, gin.H{"message": "updated answer", "record": gin.H{"text": uar.Text, "version": uar.Version}})
+}
+
+// 'revision' parameter is the revision number of the post
+func getRevision(h *Handler, c *gin.Context, post string, postId int64) (models.RevisionResponse, error) {
+	revision, err := strconv.ParseInt(c.Param("revision"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision parameter. need an integer"})
+		return models.RevisionResponse{}, err
+	}
+	res, err := h.revisionRepo.GetRevision(post, postId, revision)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such revision"})
+			return res, err
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.getRevision: %s: %s\n", post, err.Error())
+		return res, err
+	}
+	return res, nil
+}
+
+// 404 for deleted posts
+func checkPostExists(h *Handler, c *gin.Context, post string, postId int64) error {
+	deleted := false
+	var err error
+	switch post {
+	case "question":
+		qs, e := h.questionRepo.GetQuestionStatus(postId)
+		deleted, err = qs.DeletedAt != nil, e
+	case "answer":
+		as, e := h.answerRepo.GetAnswerStatus(postId)
+		deleted, err = as.DeletedAt != nil, e
+	default:
+		return fmt.Errorf("httphandlers.checkPostExists: invalid post type '%s'", post)
+	}
+	if err == sql.ErrNoRows || (err == nil && deleted) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no such " + post})
+		return fmt.Errorf("err")
+	}
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("httphandlers.checkPostExists: %s: %s\n", post, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package httphandlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+// GET /search?q=&tag=&tag=&limit=&offset=
+func (h *Handler) Search(c *gin.Context) {
+	limit, offset, err := getLimitOffsetQuery(c)
+	if err != nil {
+		return
+	}
+	params := models.SearchParams{
+		Query:  strings.TrimSpace(c.Query("q")),
+		Tags:   c.QueryArray("tag"),
+		Limit:  limit,
+		Offset: offset,
+	}
+	if validationErrs := params.Validate(); len(validationErrs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": validationErrs})
+		return
+	}
+	res, err := h.searchRepo.Search(params)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.Search: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
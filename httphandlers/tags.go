@@ -0,0 +1,42 @@
+package httphandlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) ListTags(c *gin.Context) {
+	limit, offset, err := getLimitOffsetQuery(c)
+	if err != nil {
+		return
+	}
+	tags, err := h.tagRepo.GetTags(limit, offset)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ListTags: get tags: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+func (h *Handler) ListQuestionsByTag(c *gin.Context) {
+	tag := strings.ToLower(strings.TrimSpace(c.Param("tag")))
+	limit, offset, err := getLimitOffsetQuery(c)
+	if err != nil {
+		return
+	}
+	questions, err := h.tagRepo.GetQuestionsByTag(tag, limit, offset)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such tag"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ListQuestionsByTag: get questions by tag: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tag": tag, "questions": questions})
+}
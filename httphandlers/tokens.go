@@ -0,0 +1,217 @@
+package httphandlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/betelgeuse-7/qa/service/jwtauth"
+	"github.com/betelgeuse-7/qa/storage/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// refresh token cookie is only sent to /token/refresh, and /logout
+	_RT_COOKIE_PATH = "/api/v1"
+	_CSRF_HEADER    = "X-CSRF-Token"
+)
+
+// tokens in a response body, for clients that don't use cookies. they send the access token
+// in the 'Authorization: Bearer <jwt>' header.
+type tokensResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	// seconds
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+func newTokensResponse(at, rt string) tokensResponse {
+	return tokensResponse{AccessToken: at, RefreshToken: rt, TokenType: "Bearer", ExpiresIn: int64(jwtauth.AT_EXPIRY.Seconds())}
+}
+
+// a new access token, and a new refresh token (a new session) for the user. tokens are set as
+// cookies, unless inBody is true.
+func issueTokens(h *Handler, c *gin.Context, userId int64, role string, inBody bool) (tokensResponse, error) {
+	rt, rtHash, err := jwtauth.NewRefreshToken()
+	if err != nil {
+		return tokensResponse{}, err
+	}
+	if err := h.refreshTokenRepo.SaveRefreshToken(userId, rtHash, time.Now().Add(jwtauth.RT_EXPIRY)); err != nil {
+		return tokensResponse{}, err
+	}
+	at, err := h.jwtRepo.NewToken(userId, role, jwtauth.NewAccessToken)
+	if err != nil {
+		return tokensResponse{}, err
+	}
+	if !inBody {
+		if err := setAuthCookies(h, c, at, rt); err != nil {
+			return tokensResponse{}, err
+		}
+	}
+	return newTokensResponse(at, rt), nil
+}
+
+// also sets a new csrf token cookie. see checkCSRFToken.
+func setAuthCookies(h *Handler, c *gin.Context, at, rt string) error {
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	cookieHttpOnly := true
+	c.SetCookie(h.atCookieName, at, int(jwtauth.AT_EXPIRY.Seconds()), "/", h.domain, h.useHTTPS, cookieHttpOnly)
+	c.SetCookie(h.rtCookieName, rt, int(jwtauth.RT_EXPIRY.Seconds()), _RT_COOKIE_PATH, h.domain, h.useHTTPS, cookieHttpOnly)
+	// scripts must be able to read this one
+	c.SetCookie(h.csrfCookieName, csrfToken, int(jwtauth.RT_EXPIRY.Seconds()), "/", h.domain, h.useHTTPS, false)
+	return nil
+}
+
+func clearAuthCookies(h *Handler, c *gin.Context) {
+	cookieHttpOnly := true
+	c.SetCookie(h.atCookieName, "", -1, "/", h.domain, h.useHTTPS, cookieHttpOnly)
+	c.SetCookie(h.rtCookieName, "", -1, _RT_COOKIE_PATH, h.domain, h.useHTTPS, cookieHttpOnly)
+	c.SetCookie(h.csrfCookieName, "", -1, "/", h.domain, h.useHTTPS, false)
+}
+
+func newCSRFToken() (string, error) {
+	bx := make([]byte, 32)
+	if _, err := rand.Read(bx); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bx), nil
+}
+
+type refreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// the refresh token is either in the refresh token cookie, or in the json body. returns the
+// token, and whether it came from the body.
+//
+// aborts the request, and returns an empty token if there is no token, or the csrf check of
+// the cookie fails.
+func getRefreshToken(h *Handler, c *gin.Context) (string, bool) {
+	if rt, err := c.Cookie(h.rtCookieName); err == nil && len(rt) > 0 {
+		if !checkCSRFToken(h, c) {
+			return "", false
+		}
+		return rt, false
+	}
+	var payload refreshTokenPayload
+	if err := c.ShouldBindJSON(&payload); err != nil || len(payload.RefreshToken) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing refresh token cookie, or refresh_token"})
+		return "", false
+	}
+	return payload.RefreshToken, true
+}
+
+// exchange the refresh token for a new access token, and a new refresh token. the old
+// refresh token can't be used again. tokens are returned the way the refresh token came in,
+// either as cookies, or in the body.
+func (h *Handler) RefreshToken(c *gin.Context) {
+	rt, inBody := getRefreshToken(h, c)
+	if len(rt) == 0 {
+		return
+	}
+	newRt, newRtHash, err := jwtauth.NewRefreshToken()
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RefreshToken: new refresh token: %s\n", err.Error())
+		return
+	}
+	userId, err := h.refreshTokenRepo.RotateRefreshToken(jwtauth.HashRefreshToken(rt), newRtHash, time.Now().Add(jwtauth.RT_EXPIRY))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			clearAuthCookies(h, c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": models.ERROR_REFRESH_TOKEN_INVALID})
+			return
+		}
+		switch errMsg := err.Error(); errMsg {
+		case models.ERROR_REFRESH_TOKEN_REUSED:
+			h.logger.Info("*Handler.RefreshToken: reused refresh token. revoked the token family\n")
+			fallthrough
+		case models.ERROR_REFRESH_TOKEN_INVALID, models.ERROR_REFRESH_TOKEN_EXPIRED:
+			clearAuthCookies(h, c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RefreshToken: rotate refresh token: %s\n", err.Error())
+		return
+	}
+	// the role may have changed since the last refresh
+	role, err := h.userRepo.GetUserRole(userId)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RefreshToken: get user role: %s\n", err.Error())
+		return
+	}
+	at, err := h.jwtRepo.NewToken(userId, role, jwtauth.NewAccessToken)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RefreshToken: new access token: %s\n", err.Error())
+		return
+	}
+	if inBody {
+		c.JSON(http.StatusOK, gin.H{"message": "refreshed tokens", "tokens": newTokensResponse(at, newRt)})
+		return
+	}
+	if err := setAuthCookies(h, c, at, newRt); err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.RefreshToken: set auth cookies: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "refreshed tokens"})
+}
+
+// revoke the session of the refresh token. the access token stays valid until it expires, but
+// it's short-lived.
+func (h *Handler) Logout(c *gin.Context) {
+	// the cookies are cleared even without a refresh token, like when it's expired
+	if rt := presentRefreshToken(h, c); len(rt) > 0 {
+		if err := h.refreshTokenRepo.RevokeRefreshToken(jwtauth.HashRefreshToken(rt)); err != nil && err != sql.ErrNoRows {
+			h.logger.Error("*Handler.Logout: revoke refresh token: %s\n", err.Error())
+		}
+	}
+	clearAuthCookies(h, c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// like getRefreshToken, but doesn't abort. a cookie without a valid csrf token isn't revoked.
+func presentRefreshToken(h *Handler, c *gin.Context) string {
+	if rt, err := c.Cookie(h.rtCookieName); err == nil && len(rt) > 0 {
+		if !validCSRFToken(h, c) {
+			return ""
+		}
+		return rt
+	}
+	var payload refreshTokenPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		return ""
+	}
+	return payload.RefreshToken
+}
+
+// revoke every session of the user
+func (h *Handler) LogoutEverywhere(c *gin.Context) {
+	userId := c.GetInt64(ContextUserIdKey)
+	if userId <= 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+	if err := h.refreshTokenRepo.RevokeAllRefreshTokens(userId); err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.LogoutEverywhere: revoke all refresh tokens: %s\n", err.Error())
+		return
+	}
+	clearAuthCookies(h, c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out everywhere"})
+}
+
+// public keys that verify access tokens, for other services
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.jwtRepo.JWKS())
+}
@@ -0,0 +1,35 @@
+package httphandlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLogoutWithoutRefreshTokenClearsCookies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &Handler{atCookieName: "access-token", rtCookieName: "refresh-token", csrfCookieName: "csrf-token"}
+	r := gin.New()
+	r.POST("/logout", h.Logout)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "access-token", Value: "at"})
+	req.AddCookie(&http.Cookie{Name: "csrf-token", Value: "csrf"})
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	cleared := map[string]bool{}
+	for _, c := range w.Result().Cookies() {
+		if c.MaxAge < 0 {
+			cleared[c.Name] = true
+		}
+	}
+	for _, name := range []string{"access-token", "refresh-token", "csrf-token"} {
+		if !cleared[name] {
+			t.Errorf("cookie '%s' isn't cleared", name)
+		}
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"database/sql"
 
 	"github.com/betelgeuse-7/qa/service/hashpwd"
-	"github.com/betelgeuse-7/qa/service/jwtauth"
 	"github.com/betelgeuse-7/qa/storage/models"
 	"github.com/betelgeuse-7/qa/storage/postgres"
 	"github.com/gin-gonic/gin"
@@ -46,18 +45,16 @@ func (h *Handler) NewUser(c *gin.Context) {
 		h.logger.Error("NewUser: %s\n", err.Error())
 		return
 	}
-	at, err := h.jwtRepo.NewToken(userId, jwtauth.NewAccessToken)
-	if err != nil {
+	if _, err := issueTokens(h, c, userId, models.ROLE_USER, false); err != nil {
 		c.Status(http.StatusInternalServerError)
 		h.logger.Error("NewUser: %s\n", err.Error())
 		return
 	}
-	cookieHttpOnly := true
-	c.SetCookie(h.atCookieName, at, int(jwtauth.AT_EXPIRY.Seconds()), "/", h.domain, h.useHTTPS, cookieHttpOnly)
 	c.JSON(http.StatusCreated, gin.H{"message": "user registered successfully"})
 }
 
-// set access-token cookie after a successfull log in
+// set access-token, and refresh-token cookies after a successfull log in. or, if the client asks
+// for it with "return_tokens": true, return the tokens in the body instead.
 func (h *Handler) Login(c *gin.Context) {
 	ulp := &models.UserLoginPayload{}
 	if err := c.BindJSON(ulp); err != nil {
@@ -89,17 +86,17 @@ func (h *Handler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "wrong password"})
 		return
 	}
-	t, err := h.jwtRepo.NewToken(ulr.UserId, jwtauth.NewAccessToken)
+	tokens, err := issueTokens(h, c, ulr.UserId, ulr.Role, ulp.ReturnTokens)
 	if err != nil {
 		c.Status(http.StatusInternalServerError)
-		h.logger.Error("*Handler.Error: new token: %s\n", err.Error())
+		h.logger.Error("*Handler.Login: issue tokens: %s\n", err.Error())
 		return
 	}
-	cookieMaxAge := int(jwtauth.AT_EXPIRY.Seconds())
-	cookiePath := "/"
-	cookieHttpOnly := true
-	c.SetCookie(h.atCookieName, t, cookieMaxAge, cookiePath, h.domain, h.useHTTPS, cookieHttpOnly)
 	c.Set(ContextUserIdKey, ulr.UserId)
+	if ulp.ReturnTokens {
+		c.JSON(http.StatusOK, gin.H{"message": "login successful (no redirect)", "tokens": tokens})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "login successful (no redirect)"})
 }
 
@@ -135,6 +132,12 @@ func (h *Handler) DeleteUser(c *gin.Context) {
 		h.logger.Error("*Handler.DeleteUser: delete user: %s\n", err.Error())
 		return
 	}
+	if err := h.refreshTokenRepo.RevokeAllRefreshTokens(userId); err != nil {
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.DeleteUser: revoke all refresh tokens: %s\n", err.Error())
+		return
+	}
+	clearAuthCookies(h, c)
 	c.JSON(http.StatusOK, gin.H{"message": "deleted user"})
 }
 
@@ -165,3 +168,26 @@ func (h *Handler) ViewUserProfile(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, upr)
 }
+
+// query parameters are 'limit', and 'offset'
+func (h *Handler) ViewReputationHistory(c *gin.Context) {
+	userId, err := getInt64IdParam(c)
+	if err != nil {
+		return
+	}
+	limit, offset, err := getLimitOffsetQuery(c)
+	if err != nil {
+		return
+	}
+	res, err := h.reputationRepo.GetReputationHistory(userId, limit, offset)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no such user"})
+			return
+		}
+		c.Status(http.StatusInternalServerError)
+		h.logger.Error("*Handler.ViewReputationHistory: %s\n", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
+	"os"
+
 	"github.com/betelgeuse-7/qa/cmd"
-	"github.com/betelgeuse-7/qa/config"
 )
 
 func main() {
-	conf := config.NewAppConfig()
-	if err := conf.Parse("./config/conf.json"); err != nil {
-		panic("Parsing conf.json: " + err.Error() + "\n")
-	}
-	cmd.RunQARestAPI(conf)
+	os.Exit(cmd.Execute(os.Args[1:]))
 }
@@ -0,0 +1,118 @@
+package badges
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/betelgeuse-7/qa/storage/models"
+)
+
+// what happened. the rules that listen to the kind of an event are evaluated for the subject of
+// the event; the author of the post for votes, and accepts, and the actor otherwise.
+const (
+	EVENT_QUESTION_CREATED = "question_created"
+	EVENT_ANSWER_CREATED   = "answer_created"
+	EVENT_QUESTION_UPVOTED = "question_upvoted"
+	EVENT_ANSWER_UPVOTED   = "answer_upvoted"
+	EVENT_ANSWER_ACCEPTED  = "answer_accepted"
+)
+
+type Event struct {
+	Kind    string
+	ActorId int64
+	// "question", or "answer"
+	PostType string
+	PostId   int64
+}
+
+type rule struct {
+	badge  string
+	events []string
+	// the badge is awarded with the post of the event
+	withPost bool
+	check    func(s models.BadgeStats, now time.Time) bool
+}
+
+// every event is evaluated for the veteran badge
+var allEvents = []string{EVENT_QUESTION_CREATED, EVENT_ANSWER_CREATED, EVENT_QUESTION_UPVOTED,
+	EVENT_ANSWER_UPVOTED, EVENT_ANSWER_ACCEPTED}
+
+var rules = []rule{
+	{models.BADGE_FIRST_QUESTION, []string{EVENT_QUESTION_CREATED}, true, func(s models.BadgeStats, _ time.Time) bool {
+		return s.Questions >= 1
+	}},
+	{models.BADGE_FIRST_ANSWER, []string{EVENT_ANSWER_CREATED}, true, func(s models.BadgeStats, _ time.Time) bool {
+		return s.Answers >= 1
+	}},
+	{models.BADGE_NICE_QUESTION, []string{EVENT_QUESTION_UPVOTED}, true, func(s models.BadgeStats, _ time.Time) bool {
+		return s.PostUpvotes >= 10
+	}},
+	{models.BADGE_NICE_ANSWER, []string{EVENT_ANSWER_UPVOTED}, true, func(s models.BadgeStats, _ time.Time) bool {
+		return s.PostUpvotes >= 10
+	}},
+	{models.BADGE_ACCEPTED, []string{EVENT_ANSWER_ACCEPTED}, true, func(s models.BadgeStats, _ time.Time) bool {
+		return s.AcceptedAnswers >= 1
+	}},
+	{models.BADGE_CURIOUS, []string{EVENT_QUESTION_CREATED}, false, func(s models.BadgeStats, _ time.Time) bool {
+		return s.Questions >= 10
+	}},
+	{models.BADGE_VETERAN, allEvents, false, func(s models.BadgeStats, now time.Time) bool {
+		return now.Sub(s.CreatedAt) >= 100*24*time.Hour
+	}},
+}
+
+type Engine struct {
+	repo models.BadgeRepository
+}
+
+func NewEngine(repo models.BadgeRepository) *Engine {
+	return &Engine{repo: repo}
+}
+
+// awards the badges the event earned, and returns them
+func (e *Engine) Evaluate(ev Event) ([]string, error) {
+	matching := []rule{}
+	for _, r := range rules {
+		for _, kind := range r.events {
+			if kind == ev.Kind {
+				matching = append(matching, r)
+				break
+			}
+		}
+	}
+	if len(matching) == 0 {
+		return nil, fmt.Errorf("*badges.Engine.Evaluate: no rules for event '%s'", ev.Kind)
+	}
+	subject := ev.ActorId
+	switch ev.Kind {
+	case EVENT_QUESTION_UPVOTED, EVENT_ANSWER_UPVOTED, EVENT_ANSWER_ACCEPTED:
+		authorId, err := e.repo.GetPostAuthor(ev.PostType, ev.PostId)
+		if err != nil {
+			return nil, err
+		}
+		subject = authorId
+	}
+	stats, err := e.repo.GetBadgeStats(subject, ev.PostType, ev.PostId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	awarded := []string{}
+	for _, r := range matching {
+		if !r.check(stats, now) {
+			continue
+		}
+		post := ""
+		if r.withPost {
+			post = ev.PostType
+		}
+		ok, err := e.repo.AwardBadge(subject, r.badge, post, ev.PostId)
+		if err != nil {
+			return awarded, err
+		}
+		if ok {
+			awarded = append(awarded, r.badge)
+		}
+	}
+	return awarded, nil
+}
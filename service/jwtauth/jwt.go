@@ -1,7 +1,12 @@
 package jwtauth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/betelgeuse-7/qa/config"
@@ -9,57 +14,190 @@ import (
 )
 
 const (
-	AT_EXPIRY = (time.Hour * 24) * 2 // 2 days
+	// access tokens can't be revoked, so they are short-lived
+	AT_EXPIRY = time.Minute * 15
+	RT_EXPIRY = (time.Hour * 24) * 30 // 30 days
+	// tolerated clock difference between servers, when checking 'exp', 'iat', and 'nbf'
+	_CLOCK_LEEWAY = time.Second * 30
 )
 
 type TokenRepo struct {
 	cfg *config.ConfigJwt
+	// kid -> key
+	keys map[string]*signingKey
+	// algorithms of all the keys
+	validMethods []string
 }
 
-func NewTokenRepo(cfg *config.ConfigJwt) *TokenRepo {
-	return &TokenRepo{cfg: cfg}
+// reads, and parses the key files
+func NewTokenRepo(cfg *config.ConfigJwt) (*TokenRepo, error) {
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	active, ok := keys[cfg.ActiveKid]
+	if !ok {
+		return nil, fmt.Errorf("jwtauth: no key with the active kid '%s'", cfg.ActiveKid)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("jwtauth: active key '%s' is a public key. need a private key to sign tokens", cfg.ActiveKid)
+	}
+	methods := map[string]bool{}
+	validMethods := []string{}
+	for _, v := range keys {
+		if alg := v.method.Alg(); !methods[alg] {
+			methods[alg] = true
+			validMethods = append(validMethods, alg)
+		}
+	}
+	return &TokenRepo{cfg: cfg, keys: keys, validMethods: validMethods}, nil
 }
 
+// not a pointer to jwt.StandardClaims. a nil pointer makes claims.Valid() panic, when a token
+// has none of the standard claims.
 type AccessToken struct {
-	*jwt.StandardClaims
+	jwt.StandardClaims
 	UserId int64 `json:"user_id"`
+	// role of the user when the token is issued. a new role is in the token after the next
+	// refresh, so it takes at most AT_EXPIRY to take effect.
+	Role string `json:"role"`
 }
 
-type tokenBuilderFn func(*TokenRepo, int64) (string, error)
+type tokenBuilderFn func(*TokenRepo, int64, string) (string, error)
 
-func (t *TokenRepo) NewToken(userId int64, fn tokenBuilderFn) (string, error) {
-	return fn(t, userId)
+// userId, role
+func (t *TokenRepo) NewToken(userId int64, role string, fn tokenBuilderFn) (string, error) {
+	return fn(t, userId, role)
 }
 
-func NewAccessToken(tr *TokenRepo, userId int64) (string, error) {
-	return newToken(tr, "access", userId)
+func NewAccessToken(tr *TokenRepo, userId int64, role string) (string, error) {
+	return newToken(tr, "access", userId, role)
 }
 
-func newToken(tr *TokenRepo, type_ string, userId int64) (string, error) {
+func newToken(tr *TokenRepo, type_ string, userId int64, role string) (string, error) {
 	switch type_ {
 	case "access":
-		atClaims := &AccessToken{StandardClaims: &jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(AT_EXPIRY).Unix(),
-		}, UserId: userId}
-		t := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-		return t.SignedString(tr.cfg.SecretKey)
+		now := time.Now()
+		atClaims := &AccessToken{StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(AT_EXPIRY).Unix(),
+			IssuedAt:  now.Unix(),
+			Issuer:    tr.cfg.Issuer,
+			Audience:  tr.cfg.Audience,
+		}, UserId: userId, Role: role}
+		key := tr.keys[tr.cfg.ActiveKid]
+		t := jwt.NewWithClaims(key.method, atClaims)
+		// ParseToken picks the verification key by kid
+		t.Header["kid"] = key.kid
+		return t.SignedString(key.signKey)
+	}
+	return "", errors.New("invalid token type: '" + type_ + "'")
+}
+
+// refresh tokens are opaque random strings, not JWTs. only their hashes are stored, so
+// a leaked database doesn't leak usable tokens.
+//
+// returns the raw token (to be sent to the client), and its hash
+func NewRefreshToken() (string, string, error) {
+	bx := make([]byte, 32)
+	if _, err := rand.Read(bx); err != nil {
+		return "", "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(bx)
+	return raw, HashRefreshToken(raw), nil
+}
+
+// hex encoded sha256. refresh tokens have enough entropy, so they don't need bcrypt.
+func HashRefreshToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// api keys are opaque random strings too. prefix makes them recognizable.
+//
+// returns the raw key (to be shown to the user once), and its hash
+func NewApiKey(prefix string) (string, string, error) {
+	raw, _, err := NewRefreshToken()
+	if err != nil {
+		return "", "", err
 	}
-	return "", errors.New("invalid token type: '" + type_ + "\n")
+	raw = prefix + raw
+	return raw, HashApiKey(raw), nil
 }
 
-// use *AccessToken as the claims. RefreshToken, and AccessToken is the same basically
+func HashApiKey(raw string) string {
+	return HashRefreshToken(raw)
+}
+
+// use *AccessToken as the claims. only tokens signed with the algorithm of the key of their kid,
+// and with all of the required claims ('exp', 'iat', 'iss', 'aud', 'user_id', 'role') are accepted.
 func (tr *TokenRepo) ParseToken(raw string) (*jwt.Token, *AccessToken, error) {
 	claims := &AccessToken{}
-	// !
-	// If there's no 'exp' field in JWT payload, this function leads to nil pointer dereference
-	// error, and the server crashes. Shouldn't this function return an error, in case a required
-	// field is non-existent?
-	// We can easily crash the server, by sending a JWT with a missing 'exp' field. This is stupid.
-	tok, err := jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
-		return tr.cfg.SecretKey, nil
+	parser := &jwt.Parser{
+		ValidMethods: tr.validMethods,
+		// jwt.StandardClaims.Valid() treats missing claims as valid. claims are checked below.
+		SkipClaimsValidation: true,
+	}
+	tok, err := parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok || len(kid) == 0 {
+			return nil, errors.New("missing 'kid' header")
+		}
+		// any configured key, not only the active one. tokens signed with a key that is being
+		// rotated out stay valid until they expire.
+		key, ok := tr.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid: '%s'", kid)
+		}
+		// ValidMethods allows the algorithms of all the keys. the token must use the algorithm of
+		// its own key, otherwise an RS256 public key could be used as an HS256 secret.
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method '%v' for kid '%s'", t.Header["alg"], kid)
+		}
+		return key.verifyKey, nil
 	})
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := tr.validateClaims(claims, time.Now()); err != nil {
+		return nil, nil, err
+	}
 	return tok, claims, nil
 }
+
+func (tr *TokenRepo) validateClaims(c *AccessToken, now time.Time) error {
+	switch {
+	case c.ExpiresAt == 0:
+		return errors.New("missing 'exp' claim")
+	case c.IssuedAt == 0:
+		return errors.New("missing 'iat' claim")
+	case len(c.Issuer) == 0:
+		return errors.New("missing 'iss' claim")
+	case len(c.Audience) == 0:
+		return errors.New("missing 'aud' claim")
+	case c.UserId <= 0:
+		return errors.New("missing, or invalid 'user_id' claim")
+	case len(c.Role) == 0:
+		return errors.New("missing 'role' claim")
+	}
+	leeway := int64(_CLOCK_LEEWAY.Seconds())
+	if now.Unix() > c.ExpiresAt+leeway {
+		return errors.New("token is expired")
+	}
+	if c.IssuedAt > now.Unix()+leeway {
+		return errors.New("token is issued in the future")
+	}
+	if c.NotBefore != 0 && c.NotBefore > now.Unix()+leeway {
+		return errors.New("token is not valid yet")
+	}
+	// we never issue tokens that live longer than AT_EXPIRY
+	if c.ExpiresAt-c.IssuedAt > int64(AT_EXPIRY.Seconds()) {
+		return errors.New("token lifetime is too long")
+	}
+	if c.Issuer != tr.cfg.Issuer {
+		return fmt.Errorf("unexpected issuer: '%s'", c.Issuer)
+	}
+	if c.Audience != tr.cfg.Audience {
+		return fmt.Errorf("unexpected audience: '%s'", c.Audience)
+	}
+	return nil
+}
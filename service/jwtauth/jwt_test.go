@@ -0,0 +1,153 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/golang-jwt/jwt"
+)
+
+var testSecret = []byte("test-secret-test-secret-test-secret")
+
+// an HS256 key 'k1', which is active, and the public half of an RSA key 'r1'. returns the RSA
+// private key, and the PEM of its public key.
+func newTestRepo(t *testing.T) (*TokenRepo, *rsa.PrivateKey, []byte) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	file := filepath.Join(t.TempDir(), "r1.pem")
+	if err := os.WriteFile(file, pubPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := NewTokenRepo(&config.ConfigJwt{
+		Keys:      []config.ConfigJwtKey{{Kid: "k1", Secret: testSecret}},
+		KeyFiles:  []config.ConfigJwtKeyFile{{Kid: "r1", File: file}},
+		ActiveKid: "k1",
+		Issuer:    "qa-api",
+		Audience:  "qa-api",
+		Algorithm: "HS256",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr, rsaKey, pubPEM
+}
+
+func validClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"exp":     now.Add(10 * time.Minute).Unix(),
+		"iat":     now.Unix(),
+		"iss":     "qa-api",
+		"aud":     "qa-api",
+		"user_id": 1,
+		"role":    "user",
+	}
+}
+
+// valid claims, with the changes. a nil value deletes the claim.
+func claimsWith(now time.Time, changes jwt.MapClaims) jwt.MapClaims {
+	c := validClaims(now)
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+// an empty kid leaves out the header
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestParseTokenAcceptsValidToken(t *testing.T) {
+	tr, _, _ := newTestRepo(t)
+	at, err := NewAccessToken(tr, 7, "moderator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, claims, err := tr.ParseToken(at)
+	if err != nil {
+		t.Fatalf("valid token rejected: %s", err)
+	}
+	if claims.UserId != 7 || claims.Role != "moderator" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	hand := sign(t, jwt.SigningMethodHS256, testSecret, "k1", validClaims(time.Now()))
+	if _, _, err := tr.ParseToken(hand); err != nil {
+		t.Fatalf("valid hand built token rejected: %s", err)
+	}
+}
+
+func TestParseTokenRejectsHostileTokens(t *testing.T) {
+	tr, rsaKey, pubPEM := newTestRepo(t)
+	now := time.Now()
+	hs := func(claims jwt.MapClaims) string {
+		return sign(t, jwt.SigningMethodHS256, testSecret, "k1", claims)
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no exp", hs(claimsWith(now, jwt.MapClaims{"exp": nil}))},
+		{"no iat", hs(claimsWith(now, jwt.MapClaims{"iat": nil}))},
+		{"no iss", hs(claimsWith(now, jwt.MapClaims{"iss": nil}))},
+		{"no aud", hs(claimsWith(now, jwt.MapClaims{"aud": nil}))},
+		{"no user_id", hs(claimsWith(now, jwt.MapClaims{"user_id": nil}))},
+		{"string user_id", hs(claimsWith(now, jwt.MapClaims{"user_id": "1"}))},
+		{"zero user_id", hs(claimsWith(now, jwt.MapClaims{"user_id": 0}))},
+		{"no role", hs(claimsWith(now, jwt.MapClaims{"role": nil}))},
+		{"wrong iss", hs(claimsWith(now, jwt.MapClaims{"iss": "evil"}))},
+		{"wrong aud", hs(claimsWith(now, jwt.MapClaims{"aud": "other-service"}))},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "k1", validClaims(now))},
+		{"HS384 against an HS256 key", sign(t, jwt.SigningMethodHS384, testSecret, "k1", validClaims(now))},
+		{"RS256 against an HS256 key", sign(t, jwt.SigningMethodRS256, rsaKey, "k1", validClaims(now))},
+		{"HS256 with an RSA public key as the secret", sign(t, jwt.SigningMethodHS256, pubPEM, "r1", validClaims(now))},
+		{"unknown kid", sign(t, jwt.SigningMethodHS256, testSecret, "k2", validClaims(now))},
+		{"missing kid", sign(t, jwt.SigningMethodHS256, testSecret, "", validClaims(now))},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, []byte("another-secret"), "k1", validClaims(now))},
+		{"malformed", "not.a.token"},
+		{"empty", ""},
+		{"expired", hs(claimsWith(now, jwt.MapClaims{
+			"iat": now.Add(-time.Hour).Unix(), "exp": now.Add(-time.Hour + AT_EXPIRY).Unix(),
+		}))},
+		{"iat in the future", hs(claimsWith(now, jwt.MapClaims{
+			"iat": now.Add(time.Hour).Unix(), "exp": now.Add(time.Hour + 5*time.Minute).Unix(),
+		}))},
+		{"lifetime longer than AT_EXPIRY", hs(claimsWith(now, jwt.MapClaims{
+			"exp": now.Add(AT_EXPIRY + time.Hour).Unix(),
+		}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := tr.ParseToken(tt.token); err == nil {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package jwtauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/golang-jwt/jwt"
+)
+
+const _MIN_RSA_KEY_BITS = 2048
+
+type signingKey struct {
+	kid string
+	// a token with this kid must be signed with this method. anything else is rejected, so that
+	// a public key can never be used as an HMAC secret.
+	method jwt.SigningMethod
+	// []byte, *rsa.PrivateKey, or ed25519.PrivateKey. nil for verification only keys.
+	signKey interface{}
+	// []byte, *rsa.PublicKey, or ed25519.PublicKey
+	verifyKey interface{}
+}
+
+func loadKeys(cfg *config.ConfigJwt) (map[string]*signingKey, error) {
+	keys := map[string]*signingKey{}
+	for _, v := range cfg.Keys {
+		keys[v.Kid] = &signingKey{
+			kid:       v.Kid,
+			method:    jwt.GetSigningMethod(cfg.Algorithm),
+			signKey:   v.Secret,
+			verifyKey: v.Secret,
+		}
+	}
+	for _, v := range cfg.KeyFiles {
+		k, err := loadKeyFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("jwtauth: key '%s': %w", v.Kid, err)
+		}
+		keys[v.Kid] = k
+	}
+	return keys, nil
+}
+
+func loadKeyFile(kf config.ConfigJwtKeyFile) (*signingKey, error) {
+	bx, err := os.ReadFile(kf.File)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bx)
+	if block == nil {
+		return nil, errors.New("not a PEM encoded key")
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := &signingKey{kid: kf.Kid}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.method, k.verifyKey = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.method, k.verifyKey = jwt.SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("unsupported key type '%T'. need an RSA, or an Ed25519 key", parsed)
+	}
+	if pub, ok := k.verifyKey.(*rsa.PublicKey); ok && pub.N.BitLen() < _MIN_RSA_KEY_BITS {
+		return nil, fmt.Errorf("RSA key is too short. need at least %d bits", _MIN_RSA_KEY_BITS)
+	}
+	return k, nil
+}
+
+// https://www.rfc-editor.org/rfc/rfc7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// public keys of the asymmetric keys. HMAC secrets are never published.
+func (tr *TokenRepo) JWKS() JWKS {
+	res := JWKS{Keys: []JWK{}}
+	for _, v := range tr.keys {
+		jwk := JWK{Use: "sig", Alg: v.method.Alg(), Kid: v.kid}
+		switch pub := v.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+	sort.Slice(res.Keys, func(i, j int) bool { return res.Keys[i].Kid < res.Keys[j].Kid })
+	return res
+}
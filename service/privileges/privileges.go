@@ -0,0 +1,79 @@
+package privileges
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/betelgeuse-7/qa/config"
+)
+
+const (
+	PRIVILEGE_DOWNVOTE            = "downvote"
+	PRIVILEGE_POST_LINKS          = "post_links"
+	PRIVILEGE_UNLIMITED_QUESTIONS = "unlimited_questions"
+)
+
+// what the thresholds of a privilege are compared to
+type Standing struct {
+	AccountAgeDays int64 `json:"account_age_days"`
+	Posts          int64 `json:"posts"`
+	NetUpvotes     int64 `json:"net_upvotes"`
+}
+
+func NewStanding(createdAt time.Time, posts, netUpvotes int64, now time.Time) Standing {
+	return Standing{
+		AccountAgeDays: int64(now.Sub(createdAt) / (time.Hour * 24)),
+		Posts:          posts,
+		NetUpvotes:     netUpvotes,
+	}
+}
+
+// the thresholds of a privilege, as they are shown to the user
+type Requirements struct {
+	MinAccountAgeDays int64 `json:"min_account_age_days"`
+	MinPosts          int64 `json:"min_posts"`
+	MinNetUpvotes     int64 `json:"min_net_upvotes"`
+}
+
+type Checker struct {
+	cfg *config.ConfigPrivileges
+}
+
+func NewChecker(cfg *config.ConfigPrivileges) *Checker {
+	return &Checker{cfg: cfg}
+}
+
+func (ch *Checker) Requirements(privilege string) Requirements {
+	p := config.ConfigPrivilege{}
+	switch privilege {
+	case PRIVILEGE_DOWNVOTE:
+		p = ch.cfg.Downvote
+	case PRIVILEGE_POST_LINKS:
+		p = ch.cfg.PostLinks
+	case PRIVILEGE_UNLIMITED_QUESTIONS:
+		p = ch.cfg.UnlimitedQuestions
+	}
+	return Requirements{MinAccountAgeDays: p.MinAccountAgeDays, MinPosts: p.MinPosts, MinNetUpvotes: p.MinNetUpvotes}
+}
+
+func (ch *Checker) Has(privilege string, s Standing) bool {
+	r := ch.Requirements(privilege)
+	return s.AccountAgeDays >= r.MinAccountAgeDays && s.Posts >= r.MinPosts && s.NetUpvotes >= r.MinNetUpvotes
+}
+
+// 0 means no limit
+func (ch *Checker) QuestionsPerDay() int64 {
+	return ch.cfg.QuestionsPerDay
+}
+
+// http(s) urls, and bare 'www.' hosts. markdown links have a url in them too.
+var linkRegexp = regexp.MustCompile(`(?i)(\bhttps?://|\bwww\.)\S`)
+
+func ContainsLink(texts ...string) bool {
+	for _, t := range texts {
+		if linkRegexp.MatchString(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package purge
+
+import (
+	"context"
+	"time"
+
+	"github.com/betelgeuse-7/qa/config"
+	"github.com/betelgeuse-7/qa/service/logger"
+	"github.com/betelgeuse-7/qa/storage/models"
+)
+
+// hard-deletes soft-deleted rows older than the retention, every interval
+type Job struct {
+	repo      models.PurgeRepository
+	retention time.Duration
+	interval  time.Duration
+	logger    *logger.Logger
+}
+
+// returns nil if the purge is disabled
+func NewJob(repo models.PurgeRepository, cfg *config.ConfigRetention, logger *logger.Logger) *Job {
+	if cfg.PurgeAfterDays == 0 {
+		return nil
+	}
+	return &Job{
+		repo:      repo,
+		retention: time.Duration(cfg.PurgeAfterDays) * 24 * time.Hour,
+		interval:  time.Duration(cfg.PurgeIntervalMinutes) * time.Minute,
+		logger:    logger,
+	}
+}
+
+// runs once right away, and then every interval until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.purge()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *Job) purge() {
+	res, err := j.repo.PurgeDeleted(time.Now().Add(-j.retention))
+	if err != nil {
+		j.logger.Error("*purge.Job.purge: %s\n", err.Error())
+		return
+	}
+	if res == (models.PurgeResult{}) {
+		return
+	}
+	j.logger.Info("purged %d users, %d questions, %d answers, %d comments\n",
+		res.Users, res.Questions, res.Answers, res.Comments)
+}
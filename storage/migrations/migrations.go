@@ -0,0 +1,315 @@
+package migrations
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// every migration is a pair of files, <version>_<name>.up.sql, and <version>_<name>.down.sql.
+// versions are positive integers, and are applied in ascending order.
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// key of the advisory lock, so that two migrate commands, or servers can't migrate at once
+const _LOCK_KEY = int64(7493816204)
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	// nil if the migration is not applied
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func New(db *sqlx.DB) (*Migrator, error) {
+	migrations, err := load()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		name := e.Name()
+		direction := ""
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migrations.load: '%s' is not an .up.sql, or a .down.sql file", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || version <= 0 || len(parts) != 2 {
+			return nil, fmt.Errorf("migrations.load: '%s' doesn't start with a version, and a name", name)
+		}
+		bx, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		}
+		if m.Name != parts[1] {
+			return nil, fmt.Errorf("migrations.load: two names for version %d: '%s', and '%s'", version, m.Name, parts[1])
+		}
+		if direction == "up" {
+			m.up = string(bx)
+		} else {
+			m.down = string(bx)
+		}
+	}
+	res := []Migration{}
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migrations.load: version %d needs both an up, and a down file", m.Version)
+		}
+		res = append(res, *m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	return res, nil
+}
+
+// the latest version known to this binary
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	res := []MigrationStatus{}
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			s := MigrationStatus{Migration: mig}
+			if at, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			res = append(res, s)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// the migrations that aren't applied. unlike the others, it doesn't take the lock, so that it
+// doesn't wait for a migration that is running.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	var exists bool
+	if err := m.db.QueryRowxContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int64]time.Time{}
+	if exists {
+		var err error
+		if applied, err = appliedVersions(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+	res := []Migration{}
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			res = append(res, mig)
+		}
+	}
+	return res, nil
+}
+
+// applies every pending migration. returns the applied migrations.
+func (m *Migrator) Up() ([]Migration, error) {
+	return m.To(m.Latest())
+}
+
+// reverts the latest applied migration. returns the reverted migration, if any.
+func (m *Migrator) Down() ([]Migration, error) {
+	res := []Migration{}
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; !ok {
+				continue
+			}
+			if err := run(conn, m.migrations[i], false); err != nil {
+				return err
+			}
+			res = append(res, m.migrations[i])
+			return nil
+		}
+		return nil
+	})
+	return res, err
+}
+
+// applies the pending migrations up to, and including the version, and reverts the applied ones
+// after it. 0 reverts everything. returns the applied, or reverted migrations in the order they
+// ran.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	if version != 0 {
+		known := false
+		for _, mig := range m.migrations {
+			known = known || mig.Version == version
+		}
+		if !known {
+			return nil, fmt.Errorf("no migration with version %d", version)
+		}
+	}
+	res := []Migration{}
+	err := m.withLock(func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(context.Background(), conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := applied[mig.Version]; !ok || mig.Version <= version {
+				continue
+			}
+			if err := run(conn, mig, false); err != nil {
+				return err
+			}
+			res = append(res, mig)
+		}
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok || mig.Version > version {
+				continue
+			}
+			if err := run(conn, mig, true); err != nil {
+				return err
+			}
+			res = append(res, mig)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// advisory locks belong to a session, so everything runs on one connection
+func (m *Migrator) withLock(fn func(conn *sqlx.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", _LOCK_KEY); err != nil {
+		return fmt.Errorf("migrations: lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", _LOCK_KEY)
+	if err := ensureMigrationsTable(conn); err != nil {
+		return fmt.Errorf("migrations: schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+// a database that was set up from schema.sql by hand has the tables of the baseline, but no
+// schema_migrations. it's adopted at version 1, instead of running the baseline again, and gets
+// everything after the baseline from the next migrations.
+func ensureMigrationsTable(conn *sqlx.Conn) error {
+	ctx := context.Background()
+	var exists, hasUsers bool
+	row := conn.QueryRowxContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL, to_regclass('users') IS NOT NULL")
+	if err := row.Scan(&exists, &hasUsers); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE TABLE schema_migrations (
+		version bigint primary key,
+		name text not null,
+		applied_at timestamp with time zone not null default CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if hasUsers {
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (1, 'baseline')"); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func appliedVersions(ctx context.Context, q sqlx.QueryerContext) (map[int64]time.Time, error) {
+	rows, err := q.QueryxContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		res[version] = appliedAt
+	}
+	return res, rows.Err()
+}
+
+// a migration, and its row in schema_migrations are in the same transaction
+func run(conn *sqlx.Conn, mig Migration, up bool) error {
+	tx, err := conn.BeginTxx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	body, record, args := mig.down, "DELETE FROM schema_migrations WHERE version = $1", []interface{}{mig.Version}
+	if up {
+		body, record, args = mig.up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", []interface{}{mig.Version, mig.Name}
+	}
+	// no arguments, so the file can have many statements
+	if _, err := tx.Exec(body); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,19 @@
+package migrations
+
+import "testing"
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	migrations, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// databases from schema.sql are adopted at version 1, so it must stay the baseline
+	if len(migrations) == 0 || migrations[0].Version != 1 || migrations[0].Name != "baseline" {
+		t.Fatalf("the first migration isn't 1_baseline: %+v", migrations)
+	}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			t.Fatalf("expected version %d, got %d_%s", i+1, m.Version, m.Name)
+		}
+	}
+}
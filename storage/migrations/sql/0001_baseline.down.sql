@@ -0,0 +1,3 @@
+-- every table of the baseline. dropped together, so the order of the foreign keys doesn't matter.
+DROP TABLE comments_to_answer, comments_to_question, answer_downvotes, question_downvotes,
+    answer_upvotes, question_upvotes, question_tags, tags, answers, questions, users;
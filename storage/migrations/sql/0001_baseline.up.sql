@@ -1,3 +1,6 @@
+-- the schema.sql that databases were set up from by hand, before migrations. such databases are
+-- adopted at this version; see migrations.Migrator.
+
 CREATE TABLE users (
     user_id serial primary key,
     username varchar(255) unique not null, 
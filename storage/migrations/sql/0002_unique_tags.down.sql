@@ -0,0 +1,2 @@
+-- the merged copies of tags aren't restored
+ALTER TABLE tags DROP CONSTRAINT tags_tag_key;
@@ -0,0 +1,24 @@
+-- a tag is looked up by its name, see TagRepo. the baseline allowed the same tag many times, so
+-- the copies are merged into the one with the smallest tag_id first.
+CREATE TEMPORARY TABLE kept_tags ON COMMIT DROP AS
+    SELECT tag, min(tag_id) AS tag_id FROM tags GROUP BY tag;
+
+-- a question can have two copies of a tag, so they can't just be repointed: that could repeat
+-- the primary key
+INSERT INTO question_tags (question_id, tag_id)
+    SELECT DISTINCT qt.question_id, k.tag_id
+    FROM question_tags qt
+    INNER JOIN tags t ON t.tag_id = qt.tag_id
+    INNER JOIN kept_tags k ON k.tag = t.tag
+    WHERE qt.tag_id <> k.tag_id
+ON CONFLICT DO NOTHING;
+
+DELETE FROM question_tags qt
+    USING tags t, kept_tags k
+    WHERE t.tag_id = qt.tag_id AND k.tag = t.tag AND qt.tag_id <> k.tag_id;
+
+DELETE FROM tags t
+    USING kept_tags k
+    WHERE k.tag = t.tag AND t.tag_id <> k.tag_id;
+
+ALTER TABLE tags ADD CONSTRAINT tags_tag_key UNIQUE (tag);
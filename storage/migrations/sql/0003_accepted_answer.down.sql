@@ -0,0 +1 @@
+ALTER TABLE questions DROP COLUMN accepted_answer;
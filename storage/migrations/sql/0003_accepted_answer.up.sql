@@ -0,0 +1 @@
+ALTER TABLE questions ADD COLUMN accepted_answer int references answers(answer_id);
@@ -0,0 +1,3 @@
+-- the indexes are dropped with the columns
+ALTER TABLE questions DROP COLUMN search_vector;
+ALTER TABLE answers DROP COLUMN search_vector;
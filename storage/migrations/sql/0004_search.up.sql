@@ -0,0 +1,11 @@
+-- full-text search. title matches rank higher than text matches.
+ALTER TABLE questions ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(text, '')), 'B')
+) STORED;
+
+CREATE INDEX questions_search_vector_idx ON questions USING GIN (search_vector);
+
+ALTER TABLE answers ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(text, ''))) STORED;
+
+CREATE INDEX answers_search_vector_idx ON answers USING GIN (search_vector);
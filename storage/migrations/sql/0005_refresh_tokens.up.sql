@@ -0,0 +1,16 @@
+-- refresh tokens are rotated on every use. a token, and the tokens that replaced it form a
+-- family; family is the hash of the first token of the family. if a revoked token is used again,
+-- the whole family is revoked.
+CREATE TABLE refresh_tokens (
+    token_id serial primary key,
+    token_hash char(64) unique not null, -- hex encoded sha256
+    family char(64) not null,
+    user_id int not null references users(user_id),
+    created_at timestamp with time zone default CURRENT_TIMESTAMP,
+    expires_at timestamp with time zone not null,
+    revoked_at timestamp with time zone,
+    replaced_by int references refresh_tokens(token_id)
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
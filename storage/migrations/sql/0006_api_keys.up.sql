@@ -0,0 +1,14 @@
+-- personal api keys, for scripts. like refresh tokens, only the hashes are stored.
+CREATE TABLE api_keys (
+    key_id serial primary key,
+    user_id int not null references users(user_id),
+    name varchar(100) not null,
+    key_prefix varchar(16) not null, -- shown in listings, to tell the keys apart
+    key_hash char(64) unique not null, -- hex encoded sha256
+    scopes text[] not null,
+    created_at timestamp with time zone default CURRENT_TIMESTAMP,
+    last_used_at timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
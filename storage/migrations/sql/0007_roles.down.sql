@@ -0,0 +1,2 @@
+ALTER TABLE questions DROP COLUMN locked_by, DROP COLUMN locked_at;
+ALTER TABLE users DROP COLUMN role;
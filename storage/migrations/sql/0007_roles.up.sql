@@ -0,0 +1,6 @@
+-- existing users get the 'user' role. make an admin with 'qa user create-admin'.
+ALTER TABLE users ADD COLUMN role varchar(20) not null default 'user' CHECK (role IN ('user', 'moderator', 'admin'));
+
+-- a locked question can't be answered, commented on, voted on, or edited, except by moderators
+ALTER TABLE questions ADD COLUMN locked_at timestamp with time zone;
+ALTER TABLE questions ADD COLUMN locked_by int references users(user_id);
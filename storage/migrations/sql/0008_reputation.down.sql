@@ -0,0 +1,2 @@
+DROP TABLE reputation_events;
+ALTER TABLE users DROP COLUMN reputation;
@@ -0,0 +1,19 @@
+-- sum of the deltas in reputation_events. votes cast before this migration don't count.
+ALTER TABLE users ADD COLUMN reputation int not null default 0;
+
+-- every reputation change of a user. an undone change (a retracted vote, an unaccepted answer) is
+-- a new event with the opposite delta, that references the undone event.
+CREATE TABLE reputation_events (
+    event_id serial primary key,
+    user_id int not null references users(user_id),
+    actor_id int not null references users(user_id), -- the voter, or the user who accepted the answer
+    reason varchar(50) not null,
+    delta int not null,
+    post_type varchar(20) not null CHECK (post_type IN ('question', 'answer')),
+    post_id int not null,
+    reverses int references reputation_events(event_id),
+    created_at timestamp with time zone default CURRENT_TIMESTAMP
+);
+
+CREATE INDEX reputation_events_user_id_idx ON reputation_events (user_id);
+CREATE INDEX reputation_events_post_idx ON reputation_events (post_type, post_id);
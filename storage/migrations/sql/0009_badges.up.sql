@@ -0,0 +1,10 @@
+-- badges are defined in code, see storage/models/badge.go. a user has a badge at most once.
+CREATE TABLE user_badges (
+    user_id int not null references users(user_id),
+    badge varchar(50) not null,
+    post_type varchar(20) CHECK (post_type IN ('question', 'answer')), -- the post that earned the badge, if any
+    post_id int,
+    awarded_at timestamp with time zone default CURRENT_TIMESTAMP,
+
+    PRIMARY KEY(user_id, badge)
+);
@@ -0,0 +1,26 @@
+-- every version of the title, and text of a question, and the text of an answer. revision 1 is the
+-- content before the first edit.
+CREATE TABLE question_revisions (
+    revision_id serial primary key,
+    question_id int not null references questions(question_id),
+    revision int not null,
+    edited_by int references users(user_id), -- NULL once the editor is purged
+    title text not null,
+    text text not null,
+    summary varchar(300) not null default '',
+    created_at timestamp with time zone default CURRENT_TIMESTAMP,
+
+    UNIQUE(question_id, revision)
+);
+
+CREATE TABLE answer_revisions (
+    revision_id serial primary key,
+    answer_id int not null references answers(answer_id),
+    revision int not null,
+    edited_by int references users(user_id), -- NULL once the editor is purged
+    text text not null,
+    summary varchar(300) not null default '',
+    created_at timestamp with time zone default CURRENT_TIMESTAMP,
+
+    UNIQUE(answer_id, revision)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE questions DROP COLUMN version;
+ALTER TABLE answers DROP COLUMN version;
@@ -0,0 +1,3 @@
+-- incremented on every edit of the content. the ETag of a post; see If-Match on updates.
+ALTER TABLE questions ADD COLUMN version int not null default 1;
+ALTER TABLE answers ADD COLUMN version int not null default 1;
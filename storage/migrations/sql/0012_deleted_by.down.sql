@@ -0,0 +1,2 @@
+ALTER TABLE questions DROP COLUMN deleted_by;
+ALTER TABLE answers DROP COLUMN deleted_by;
@@ -0,0 +1,4 @@
+-- who soft-deleted the post. authors can only restore the posts they deleted themselves. posts
+-- deleted before this migration have none, so only moderators can restore them.
+ALTER TABLE questions ADD COLUMN deleted_by int references users(user_id);
+ALTER TABLE answers ADD COLUMN deleted_by int references users(user_id);
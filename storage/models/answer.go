@@ -1,6 +1,8 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,10 +14,18 @@ import (
 type AnswerRepository interface {
 	NewAnswer(NewAnswerPayload) (NewAnswerResponse, error)
 	UpdateAnswer(UpdateAnswerPayload, int64) (UpdateAnswerResponse, error)
-	DeleteAnswer(int64) error
+	GetAnswer(int64) (BasicAnswerResponse, error)
+	// answerId, deletedBy
+	DeleteAnswer(int64, int64) error
 	// answerId, userId -> answer.answer_by == userId, err
 	AnswerBelongsToUser(int64, int64) (bool, error)
 	GetAnswerStatus(int64) (AnswerStatus, error)
+	// answerId, userId -> current vote of the user, err
+	UpvoteAnswer(int64, int64) (string, error)
+	DownvoteAnswer(int64, int64) (string, error)
+	RetractAnswerVote(int64, int64) (string, error)
+	// returns sql.ErrNoRows if the answer is not deleted
+	RestoreAnswer(int64) error
 }
 
 type AnswerRepo struct {
@@ -32,6 +42,40 @@ type BasicAnswerResponse struct {
 	Text              string     `json:"text" db:"text"`
 	CreatedAt         *time.Time `json:"created_at" db:"created_at"`
 	BasicUserResponse `json:"answer_author"`
+	IsAccepted        bool              `json:"is_accepted" db:"is_accepted"`
+	UpvoteCount       uint64            `json:"upvotes" db:"upvotes"`
+	DownvoteCount     uint64            `json:"downvotes" db:"downvotes"`
+	Version           int64             `json:"version" db:"version"`
+	Comments          []CommentResponse `json:"comments"`
+}
+
+func basicAnswerBuilder(sqlbuilder squirrel.StatementBuilderType) squirrel.SelectBuilder {
+	return sqlbuilder.Select("a.answer_id", "u.username", "u.handle", "u.created_at", "u.reputation",
+		"a.text", "a.created_at", "a.version",
+		"(SELECT COUNT(*) FROM answer_upvotes au WHERE au.answer_id = a.answer_id) AS upvotes",
+		"(SELECT COUNT(*) FROM answer_downvotes ad WHERE ad.answer_id = a.answer_id) AS downvotes",
+		"COALESCE(a.answer_id = q.accepted_answer, false) AS is_accepted").
+		From("answers a").InnerJoin("users u ON a.answer_by = u.user_id").
+		InnerJoin("questions q ON q.question_id = a.to_question")
+}
+
+// returns sql.ErrNoRows for deleted answers, and the answers of deleted questions
+func (a *AnswerRepo) GetAnswer(answerId int64) (BasicAnswerResponse, error) {
+	res := BasicAnswerResponse{}
+	q, args, err := basicAnswerBuilder(a.sqlbuilder).
+		Where(squirrel.Eq{"a.answer_id": answerId, "a.deleted_at": nil, "q.deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetAnswer: %w", err)
+	}
+	if err := a.db.QueryRowx(q, args...).StructScan(&res); err != nil {
+		return res, err
+	}
+	comments, err := getCommentsFor(a.db, a.sqlbuilder, COMMENT_TO_ANSWER, []int64{answerId})
+	if err != nil {
+		return res, err
+	}
+	res.Comments = comments[answerId]
+	return res, nil
 }
 
 type NewAnswerPayload struct {
@@ -50,7 +94,8 @@ type NewAnswerResponse struct {
 func (a *AnswerRepo) NewAnswer(nap NewAnswerPayload) (NewAnswerResponse, error) {
 	nar := NewAnswerResponse{}
 	q, args, err := a.sqlbuilder.Insert("answers").Columns("text", "to_question", "answer_by").
-		Values(nap.Text, nap.ToQuestion, nap.AnswerBy).Suffix("RETURNING *").ToSql()
+		Values(nap.Text, nap.ToQuestion, nap.AnswerBy).
+		Suffix("RETURNING answer_id, text, to_question, answer_by, created_at, deleted_at").ToSql()
 	if err != nil {
 		return nar, fmt.Errorf("error building NewAnswer query: %w", err)
 	}
@@ -63,22 +108,53 @@ func (a *AnswerRepo) NewAnswer(nap NewAnswerPayload) (NewAnswerResponse, error)
 
 type UpdateAnswerPayload struct {
 	Text string `json:"text"`
+	// edit summary of the revision
+	Summary  string `json:"summary"`
+	EditedBy int64  `json:"-"` // set this from request context's user id
+	// the update fails with ERROR_VERSION_MISMATCH if the stored version is different. 0 skips
+	// the check.
+	IfVersion int64 `json:"-"`
 }
 
 type UpdateAnswerResponse struct {
 	UpdateAnswerPayload
+	Version int64 `json:"version" db:"version"`
 }
 
 func (a *AnswerRepo) UpdateAnswer(uap UpdateAnswerPayload, answerId int64) (UpdateAnswerResponse, error) {
 	uar := UpdateAnswerResponse{}
-	q, args, err := a.sqlbuilder.Update("answers").Set("text", uap.Text).Where(squirrel.Eq{
+	ub := a.sqlbuilder.Update("answers").Set("text", uap.Text).Set("version", squirrel.Expr("version + 1"))
+	q, args, err := ub.Where(squirrel.Eq{
 		"answer_id": answerId, "deleted_at": nil,
-	}).Suffix("RETURNING \"text\"").ToSql()
+	}).Suffix("RETURNING \"text\", version").ToSql()
 	if err != nil {
 		return uar, fmt.Errorf("error while building query for UpdateAnswer: %w", err)
 	}
-	row := a.db.QueryRowx(q, args...)
-	err = row.StructScan(&uar)
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return uar, err
+	}
+	// sql.ErrNoRows if the answer is deleted
+	version, err := ensureBaseRevision(tx, a.sqlbuilder, "answer", answerId)
+	if err != nil {
+		tx.Rollback()
+		return uar, err
+	}
+	// the answer is locked, so the version can't change until the update
+	if uap.IfVersion > 0 && uap.IfVersion != version {
+		tx.Rollback()
+		return uar, errors.New(ERROR_VERSION_MISMATCH)
+	}
+	row := tx.QueryRowx(q, args...)
+	if err := row.StructScan(&uar); err != nil {
+		tx.Rollback()
+		return uar, err
+	}
+	if err := addRevision(tx, a.sqlbuilder, "answer", answerId, uap.EditedBy, revisionContent{Text: uar.Text}, uap.Summary); err != nil {
+		tx.Rollback()
+		return uar, err
+	}
+	err = tx.Commit()
 	return uar, err
 }
 
@@ -95,31 +171,127 @@ func (a *AnswerRepo) AnswerBelongsToUser(answerId, userId int64) (bool, error) {
 	return answerBy == userId, err
 }
 
-func (a *AnswerRepo) DeleteAnswer(answerId int64) error {
-	q, args, err := a.sqlbuilder.Update("answers").Set("deleted_at", time.Now()).Where(squirrel.Eq{
+func (a *AnswerRepo) DeleteAnswer(answerId, deletedBy int64) error {
+	q, args, err := a.sqlbuilder.Update("answers").Set("deleted_at", time.Now()).Set("deleted_by", deletedBy).Where(squirrel.Eq{
 		"deleted_at": nil,
 		"answer_id":  answerId,
 	}).ToSql()
 	if err != nil {
 		return fmt.Errorf("error while building query for DeleteAnswer: %w", err)
 	}
-	_, err = a.db.Exec(q, args...)
-	return err
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// a deleted answer can't stay accepted
+	q, args, err = a.sqlbuilder.Update("questions").Set("accepted_answer", nil).
+		Where(squirrel.Eq{"accepted_answer": answerId}).
+		Suffix("RETURNING question_by").ToSql()
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error while building query for DeleteAnswer: %w", err)
+	}
+	var questionBy int64
+	if err := tx.QueryRowx(q, args...).Scan(&questionBy); err != nil {
+		if err != sql.ErrNoRows {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+	if err := revertReputationEvent(tx, a.sqlbuilder, questionBy, REPUTATION_ANSWER_ACCEPTED, "answer", answerId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 type AnswerStatus struct {
 	UserId    int64
 	DeletedAt *time.Time
+	// locked_at of the question of the answer
+	QuestionLockedAt *time.Time
+	DeletedBy        *int64
 }
 
 func (a *AnswerRepo) GetAnswerStatus(answerId int64) (AnswerStatus, error) {
 	as := AnswerStatus{}
-	q, args, err := a.sqlbuilder.Select("answer_by", "deleted_at").From("answers").
-		Where(squirrel.Eq{"answer_id": answerId}).ToSql()
+	q, args, err := a.sqlbuilder.Select("a.answer_by", "a.deleted_at", "q.locked_at", "a.deleted_by").From("answers a").
+		InnerJoin("questions q ON q.question_id = a.to_question").
+		Where(squirrel.Eq{"a.answer_id": answerId}).ToSql()
 	if err != nil {
 		return as, fmt.Errorf("error while building query for GetAnswerStatus: %w", err)
 	}
 	row := a.db.QueryRowx(q, args...)
-	err = row.Scan(&as.UserId, &as.DeletedAt)
+	err = row.Scan(&as.UserId, &as.DeletedAt, &as.QuestionLockedAt, &as.DeletedBy)
 	return as, err
 }
+
+const (
+	ERROR_UPVOTE_OWN_ANSWER   = "cannot upvote own answer"
+	ERROR_DOWNVOTE_OWN_ANSWER = "cannot downvote own answer"
+)
+
+func (a *AnswerRepo) UpvoteAnswer(answerId, upvoteBy int64) (string, error) {
+	// can't upvote own answer
+	as, err := a.GetAnswerStatus(answerId)
+	if err != nil {
+		return "", err
+	}
+	if as.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if as.QuestionLockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
+	}
+	if as.UserId == upvoteBy {
+		return "", fmt.Errorf(ERROR_UPVOTE_OWN_ANSWER)
+	}
+	return castVote(a.db, a.sqlbuilder, "answer", VOTE_UPVOTE, answerId, upvoteBy)
+}
+
+func (a *AnswerRepo) DownvoteAnswer(answerId, downvoteBy int64) (string, error) {
+	as, err := a.GetAnswerStatus(answerId)
+	if err != nil {
+		return "", err
+	}
+	if as.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if as.QuestionLockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
+	}
+	if as.UserId == downvoteBy {
+		return "", fmt.Errorf(ERROR_DOWNVOTE_OWN_ANSWER)
+	}
+	return castVote(a.db, a.sqlbuilder, "answer", VOTE_DOWNVOTE, answerId, downvoteBy)
+}
+
+func (a *AnswerRepo) RetractAnswerVote(answerId, voteBy int64) (string, error) {
+	as, err := a.GetAnswerStatus(answerId)
+	if err != nil {
+		return "", err
+	}
+	if as.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if as.QuestionLockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
+	}
+	return retractVote(a.db, a.sqlbuilder, "answer", answerId, voteBy)
+}
+
+func (a *AnswerRepo) RestoreAnswer(answerId int64) error {
+	q, args, err := a.sqlbuilder.Update("answers").Set("deleted_at", nil).Set("deleted_by", nil).
+		Where(squirrel.Eq{"answer_id": answerId}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		Suffix("RETURNING answer_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RestoreAnswer: %w", err)
+	}
+	return a.db.QueryRowx(q, args...).Scan(&answerId)
+}
@@ -0,0 +1,218 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/okay"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// what an api key can do. requests authenticated with an access token can do everything.
+const (
+	// GET requests
+	SCOPE_READ            = "read"
+	SCOPE_QUESTIONS_WRITE = "questions:write"
+	SCOPE_ANSWERS_WRITE   = "answers:write"
+	SCOPE_COMMENTS_WRITE  = "comments:write"
+	SCOPE_VOTES_WRITE     = "votes:write"
+)
+
+var ApiKeyScopes = []string{SCOPE_READ, SCOPE_QUESTIONS_WRITE, SCOPE_ANSWERS_WRITE, SCOPE_COMMENTS_WRITE, SCOPE_VOTES_WRITE}
+
+const (
+	// raw api keys start with this, so that they can be told apart from access tokens in the
+	// Authorization header, and found by secret scanners.
+	API_KEY_PREFIX            = "qa_"
+	MAX_API_KEYS_PER_USER     = 20
+	MAX_API_KEY_NAME_LENGTH   = 100
+	ERROR_TOO_MANY_API_KEYS   = "too many api keys"
+	ERROR_API_KEY_NOT_OF_USER = "api key is not of user"
+)
+
+// only hashes of api keys go in, and out of this repository
+type ApiKeyRepository interface {
+	// userId, payload, key hash, the first few characters of the raw key
+	NewApiKey(int64, NewApiKeyPayload, string, string) (ApiKeyResponse, error)
+	// userId. revoked keys are not listed.
+	GetApiKeys(int64) ([]ApiKeyResponse, error)
+	// userId, keyId
+	RevokeApiKey(int64, int64) error
+	// key hash. returns sql.ErrNoRows for unknown, and revoked keys, and the keys of deleted users.
+	GetApiKeyAuth(string) (ApiKeyAuth, error)
+}
+
+type ApiKeyRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewApiKeyRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *ApiKeyRepo {
+	return &ApiKeyRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type NewApiKeyPayload struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (nakp *NewApiKeyPayload) Okay() (okay.ValidationErrors, error) {
+	o := okay.New()
+	o.Text(nakp.Name, "name").Required().MaxLength(MAX_API_KEY_NAME_LENGTH)
+	return o.Errors()
+}
+
+// also removes duplicate scopes
+func (nakp *NewApiKeyPayload) Validate() ([]string, error) {
+	errs, err := okay.Validate(nakp)
+	if err != nil {
+		return errs, err
+	}
+	if len(nakp.Scopes) == 0 {
+		return append(errs, "scopes: need at least one scope"), nil
+	}
+	seen := map[string]bool{}
+	scopes := []string{}
+	for _, v := range nakp.Scopes {
+		if !IsValidScope(v) {
+			errs = append(errs, fmt.Sprintf("scopes: unknown scope '%s'", v))
+			continue
+		}
+		if !seen[v] {
+			seen[v] = true
+			scopes = append(scopes, v)
+		}
+	}
+	nakp.Scopes = scopes
+	return errs, nil
+}
+
+func IsValidScope(scope string) bool {
+	for _, v := range ApiKeyScopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type ApiKeyResponse struct {
+	KeyId int64  `json:"key_id" db:"key_id"`
+	Name  string `json:"name" db:"name"`
+	// the first few characters of the key, so that the user can tell the keys apart
+	Prefix     string         `json:"prefix" db:"key_prefix"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedAt  *time.Time     `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+}
+
+// the information necessary for the auth middleware
+type ApiKeyAuth struct {
+	KeyId  int64          `db:"key_id"`
+	UserId int64          `db:"user_id"`
+	Scopes pq.StringArray `db:"scopes"`
+	// role of the owner of the key
+	Role string `db:"role"`
+}
+
+func (a *ApiKeyRepo) NewApiKey(userId int64, nakp NewApiKeyPayload, keyHash, keyPrefix string) (ApiKeyResponse, error) {
+	res := ApiKeyResponse{}
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return res, err
+	}
+	// locks the user, so that concurrent requests are counted one after the other
+	q, args, err := a.sqlbuilder.Select("user_id").From("users").
+		Where(squirrel.Eq{"user_id": userId}).Suffix("FOR UPDATE").ToSql()
+	if err != nil {
+		tx.Rollback()
+		return res, fmt.Errorf("error while building query for NewApiKey: %w", err)
+	}
+	var locked int64
+	if err := tx.QueryRowx(q, args...).Scan(&locked); err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	q, args, err = a.sqlbuilder.Select("COUNT(*)").From("api_keys").
+		Where(squirrel.Eq{"user_id": userId, "revoked_at": nil}).ToSql()
+	if err != nil {
+		tx.Rollback()
+		return res, fmt.Errorf("error while building query for NewApiKey: %w", err)
+	}
+	var count int64
+	if err := tx.QueryRowx(q, args...).Scan(&count); err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	if count >= MAX_API_KEYS_PER_USER {
+		tx.Rollback()
+		return res, errors.New(ERROR_TOO_MANY_API_KEYS)
+	}
+	q, args, err = a.sqlbuilder.Insert("api_keys").
+		Columns("user_id", "name", "key_prefix", "key_hash", "scopes").
+		Values(userId, nakp.Name, keyPrefix, keyHash, pq.StringArray(nakp.Scopes)).
+		Suffix("RETURNING key_id, name, key_prefix, scopes, created_at, last_used_at").ToSql()
+	if err != nil {
+		tx.Rollback()
+		return res, fmt.Errorf("error while building query for NewApiKey: %w", err)
+	}
+	if err := tx.QueryRowx(q, args...).StructScan(&res); err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	err = tx.Commit()
+	return res, err
+}
+
+func (a *ApiKeyRepo) GetApiKeys(userId int64) ([]ApiKeyResponse, error) {
+	res := []ApiKeyResponse{}
+	q, args, err := a.sqlbuilder.Select("key_id", "name", "key_prefix", "scopes", "created_at", "last_used_at").
+		From("api_keys").
+		Where(squirrel.Eq{"user_id": userId, "revoked_at": nil}).
+		OrderBy("created_at DESC").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetApiKeys: %w", err)
+	}
+	err = a.db.Select(&res, q, args...)
+	return res, err
+}
+
+// returns sql.ErrNoRows if there is no such key, or it's already revoked
+func (a *ApiKeyRepo) RevokeApiKey(userId, keyId int64) error {
+	q, args, err := a.sqlbuilder.Select("user_id").From("api_keys").
+		Where(squirrel.Eq{"key_id": keyId, "revoked_at": nil}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RevokeApiKey: %w", err)
+	}
+	var ownerId int64
+	if err := a.db.QueryRowx(q, args...).Scan(&ownerId); err != nil {
+		return err
+	}
+	if ownerId != userId {
+		return errors.New(ERROR_API_KEY_NOT_OF_USER)
+	}
+	q, args, err = a.sqlbuilder.Update("api_keys").Set("revoked_at", time.Now()).
+		Where(squirrel.Eq{"key_id": keyId}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RevokeApiKey: %w", err)
+	}
+	_, err = a.db.Exec(q, args...)
+	return err
+}
+
+func (a *ApiKeyRepo) GetApiKeyAuth(keyHash string) (ApiKeyAuth, error) {
+	res := ApiKeyAuth{}
+	q, args, err := a.sqlbuilder.Update("api_keys").Set("last_used_at", time.Now()).
+		Where(squirrel.Eq{"key_hash": keyHash, "revoked_at": nil}).
+		Where("user_id IN (SELECT user_id FROM users WHERE deleted_at IS NULL)").
+		Suffix("RETURNING key_id, user_id, scopes, (SELECT role FROM users WHERE users.user_id = api_keys.user_id) AS role").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetApiKeyAuth: %w", err)
+	}
+	err = a.db.QueryRowx(q, args...).StructScan(&res)
+	return res, err
+}
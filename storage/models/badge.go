@@ -0,0 +1,231 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	BADGE_FIRST_QUESTION = "first_question"
+	BADGE_FIRST_ANSWER   = "first_answer"
+	BADGE_NICE_QUESTION  = "nice_question"
+	BADGE_NICE_ANSWER    = "nice_answer"
+	BADGE_ACCEPTED       = "accepted"
+	BADGE_CURIOUS        = "curious"
+	BADGE_VETERAN        = "veteran"
+)
+
+type BadgeDefinition struct {
+	Badge       string `json:"badge"`
+	Description string `json:"description"`
+}
+
+// in the order they are listed. the rules that award them are in service/badges.
+var BadgeDefinitions = []BadgeDefinition{
+	{BADGE_FIRST_QUESTION, "asked a question"},
+	{BADGE_FIRST_ANSWER, "answered a question"},
+	{BADGE_NICE_QUESTION, "a question with 10 upvotes"},
+	{BADGE_NICE_ANSWER, "an answer with 10 upvotes"},
+	{BADGE_ACCEPTED, "an answer accepted by the asker"},
+	{BADGE_CURIOUS, "asked 10 questions"},
+	{BADGE_VETERAN, "registered 100 days ago"},
+}
+
+func badgeDescription(badge string) string {
+	for _, d := range BadgeDefinitions {
+		if d.Badge == badge {
+			return d.Description
+		}
+	}
+	return ""
+}
+
+// a badge is awarded to a user at most once
+type BadgeRepository interface {
+	// badge definitions, and how many users have each of them
+	GetBadges() ([]BadgeResponse, error)
+	GetUserBadges(int64) ([]UserBadgeResponse, error)
+	// userId, the post of the event; "" if the event has no post, postId
+	GetBadgeStats(int64, string, int64) (BadgeStats, error)
+	// returns the author of a question, or an answer. sql.ErrNoRows if there is no such post.
+	GetPostAuthor(string, int64) (int64, error)
+	// userId, badge, the post that earned the badge; "" if there is none, postId.
+	// returns false if the user already has the badge.
+	AwardBadge(int64, string, string, int64) (bool, error)
+}
+
+type BadgeRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewBadgeRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *BadgeRepo {
+	return &BadgeRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type BadgeResponse struct {
+	BadgeDefinition
+	Awarded int64 `json:"awarded"`
+}
+
+type UserBadgeResponse struct {
+	Badge       string     `json:"badge" db:"badge"`
+	Description string     `json:"description"`
+	AwardedAt   *time.Time `json:"awarded_at" db:"awarded_at"`
+	PostType    *string    `json:"post_type,omitempty" db:"post_type"`
+	PostId      *int64     `json:"post_id,omitempty" db:"post_id"`
+}
+
+// what the badge rules look at
+type BadgeStats struct {
+	CreatedAt time.Time
+	// non-deleted posts of the user
+	Questions int64
+	Answers   int64
+	// answers of the user, accepted on the questions of other users
+	AcceptedAnswers int64
+	// upvotes of the post of the event. 0 if the event has no post.
+	PostUpvotes int64
+}
+
+func (b *BadgeRepo) GetBadges() ([]BadgeResponse, error) {
+	q, args, err := b.sqlbuilder.Select("badge", "COUNT(*)").From("user_badges").GroupBy("badge").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error while building query for GetBadges: %w", err)
+	}
+	rows, err := b.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	awarded := map[string]int64{}
+	for rows.Next() {
+		var badge string
+		var count int64
+		if err := rows.Scan(&badge, &count); err != nil {
+			return nil, err
+		}
+		awarded[badge] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	res := []BadgeResponse{}
+	for _, d := range BadgeDefinitions {
+		res = append(res, BadgeResponse{BadgeDefinition: d, Awarded: awarded[d.Badge]})
+	}
+	return res, nil
+}
+
+func (b *BadgeRepo) GetUserBadges(userId int64) ([]UserBadgeResponse, error) {
+	return getUserBadges(b.db, b.sqlbuilder, userId)
+}
+
+// also used by *UserRepo.GetUserProfile
+func getUserBadges(db *sqlx.DB, sqlbuilder squirrel.StatementBuilderType, userId int64) ([]UserBadgeResponse, error) {
+	res := []UserBadgeResponse{}
+	q, args, err := sqlbuilder.Select("badge", "awarded_at", "post_type", "post_id").From("user_badges").
+		Where(squirrel.Eq{"user_id": userId}).OrderBy("awarded_at").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for getUserBadges: %w", err)
+	}
+	if err := db.Select(&res, q, args...); err != nil {
+		return res, err
+	}
+	for i := range res {
+		res[i].Description = badgeDescription(res[i].Badge)
+	}
+	return res, nil
+}
+
+func (b *BadgeRepo) GetBadgeStats(userId int64, post string, postId int64) (BadgeStats, error) {
+	res := BadgeStats{}
+	q, args, err := b.sqlbuilder.Select("created_at").From("users").
+		Where(squirrel.Eq{"user_id": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetBadgeStats: %w", err)
+	}
+	if err := b.db.QueryRowx(q, args...).Scan(&res.CreatedAt); err != nil {
+		return res, err
+	}
+	questions, args, err := b.sqlbuilder.Select("COUNT(*)").From("questions").
+		Where(squirrel.Eq{"question_by": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetBadgeStats: %w", err)
+	}
+	answers, _, err := b.sqlbuilder.Select("COUNT(*)").From("answers").
+		Where(squirrel.Eq{"answer_by": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetBadgeStats: %w", err)
+	}
+	accepted, _, err := b.sqlbuilder.Select("COUNT(*)").From("answers a").
+		InnerJoin("questions q ON q.accepted_answer = a.answer_id").
+		Where(squirrel.Eq{"a.answer_by": userId, "a.deleted_at": nil, "q.deleted_at": nil}).
+		Where("q.question_by <> a.answer_by").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetBadgeStats: %w", err)
+	}
+	// every subquery has the user id as $1
+	query := "SELECT (" + questions + "), (" + answers + "), (" + accepted + ");"
+	if err := b.db.QueryRowx(query, args...).Scan(&res.Questions, &res.Answers, &res.AcceptedAnswers); err != nil {
+		return res, err
+	}
+	upvotes := squirrel.SelectBuilder{}
+	switch post {
+	case "":
+		return res, nil
+	case "question":
+		upvotes = b.sqlbuilder.Select("COUNT(*)").From("question_upvotes").Where(squirrel.Eq{"question_id": postId})
+	case "answer":
+		upvotes = b.sqlbuilder.Select("COUNT(*)").From("answer_upvotes").Where(squirrel.Eq{"answer_id": postId})
+	default:
+		return res, fmt.Errorf("*BadgeRepo.GetBadgeStats: invalid post type '%s'", post)
+	}
+	q, args, err = upvotes.ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetBadgeStats: %w", err)
+	}
+	err = b.db.QueryRowx(q, args...).Scan(&res.PostUpvotes)
+	return res, err
+}
+
+func (b *BadgeRepo) GetPostAuthor(post string, postId int64) (int64, error) {
+	sb := squirrel.SelectBuilder{}
+	switch post {
+	case "question":
+		sb = b.sqlbuilder.Select("question_by").From("questions").Where(squirrel.Eq{"question_id": postId})
+	case "answer":
+		sb = b.sqlbuilder.Select("answer_by").From("answers").Where(squirrel.Eq{"answer_id": postId})
+	default:
+		return -1, fmt.Errorf("*BadgeRepo.GetPostAuthor: invalid post type '%s'", post)
+	}
+	q, args, err := sb.ToSql()
+	if err != nil {
+		return -1, fmt.Errorf("error while building query for GetPostAuthor: %w", err)
+	}
+	var authorId int64
+	err = b.db.QueryRowx(q, args...).Scan(&authorId)
+	return authorId, err
+}
+
+func (b *BadgeRepo) AwardBadge(userId int64, badge, post string, postId int64) (bool, error) {
+	var postType, postIdVal interface{}
+	if post != "" {
+		postType, postIdVal = post, postId
+	}
+	q, args, err := b.sqlbuilder.Insert("user_badges").Columns("user_id", "badge", "post_type", "post_id").
+		Values(userId, badge, postType, postIdVal).Suffix("ON CONFLICT (user_id, badge) DO NOTHING").ToSql()
+	if err != nil {
+		return false, fmt.Errorf("error while building query for AwardBadge: %w", err)
+	}
+	res, err := b.db.Exec(q, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/okay"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+// what a comment is written to
+const (
+	COMMENT_TO_QUESTION = "question"
+	COMMENT_TO_ANSWER   = "answer"
+)
+
+const MAX_COMMENT_LENGTH = 600
+
+type CommentRepository interface {
+	// kind (COMMENT_TO_QUESTION, or COMMENT_TO_ANSWER), payload
+	NewComment(string, NewCommentPayload) (CommentResponse, error)
+	// kind, commentId, payload
+	UpdateComment(string, int64, UpdateCommentPayload) (CommentResponse, error)
+	// kind, commentId
+	DeleteComment(string, int64) error
+	// kind, commentId
+	GetCommentStatus(string, int64) (CommentStatus, error)
+	// kind, id of the question, or the answer
+	GetComments(string, int64) ([]CommentResponse, error)
+	// kind, commentId. returns sql.ErrNoRows if the comment is not deleted.
+	RestoreComment(string, int64) error
+}
+
+type CommentRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewCommentRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *CommentRepo {
+	return &CommentRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type CommentResponse struct {
+	BasicUserResponse `json:"author" db:"author"`
+	CommentId         int64      `json:"comment_id" db:"comment_id"`
+	Text              string     `json:"text" db:"text"`
+	CreatedAt         *time.Time `json:"created_at" db:"created_at"`
+	// id of the question, or the answer this comment is written to
+	ParentId int64 `json:"-" db:"parent_id"`
+}
+
+type NewCommentPayload struct {
+	Text      string `json:"text"`
+	ParentId  int64  `json:"-"` // set this from the path parameter
+	CommentBy int64  `json:"-"` // set this from request context's user id
+}
+
+func (ncp *NewCommentPayload) Okay() (okay.ValidationErrors, error) {
+	o := okay.New()
+	o.Text(ncp.Text, "text").Required()
+	return o.Errors()
+}
+
+func (ncp *NewCommentPayload) Validate() ([]string, error) {
+	errs, err := okay.Validate(ncp)
+	if err != nil {
+		return errs, err
+	}
+	return append(errs, validateCommentLength(ncp.Text)...), nil
+}
+
+type UpdateCommentPayload struct {
+	Text string `json:"text"`
+}
+
+func (ucp *UpdateCommentPayload) Okay() (okay.ValidationErrors, error) {
+	o := okay.New()
+	o.Text(ucp.Text, "text").Required()
+	return o.Errors()
+}
+
+func (ucp *UpdateCommentPayload) Validate() ([]string, error) {
+	errs, err := okay.Validate(ucp)
+	if err != nil {
+		return errs, err
+	}
+	return append(errs, validateCommentLength(ucp.Text)...), nil
+}
+
+func validateCommentLength(text string) []string {
+	if utf8.RuneCountInString(text) > MAX_COMMENT_LENGTH {
+		return []string{fmt.Sprintf("text: a comment can be at most %d characters long", MAX_COMMENT_LENGTH)}
+	}
+	return nil
+}
+
+// table, and the column that references the question, or the answer
+func commentTable(kind string) (string, string, error) {
+	switch kind {
+	case COMMENT_TO_QUESTION:
+		return "comments_to_question", "to_question", nil
+	case COMMENT_TO_ANSWER:
+		return "comments_to_answer", "to_answer", nil
+	}
+	return "", "", fmt.Errorf("models.commentTable: invalid comment kind '%s'", kind)
+}
+
+func (cr *CommentRepo) NewComment(kind string, ncp NewCommentPayload) (CommentResponse, error) {
+	res := CommentResponse{}
+	table, parentColumn, err := commentTable(kind)
+	if err != nil {
+		return res, err
+	}
+	q, args, err := cr.sqlbuilder.Insert(table).Columns("text", parentColumn, "comment_by").
+		Values(ncp.Text, ncp.ParentId, ncp.CommentBy).
+		Suffix("RETURNING comment_id").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for NewComment: %w", err)
+	}
+	var commentId int64
+	if err := cr.db.QueryRowx(q, args...).Scan(&commentId); err != nil {
+		return res, err
+	}
+	return cr.getComment(kind, commentId)
+}
+
+func (cr *CommentRepo) UpdateComment(kind string, commentId int64, ucp UpdateCommentPayload) (CommentResponse, error) {
+	res := CommentResponse{}
+	table, _, err := commentTable(kind)
+	if err != nil {
+		return res, err
+	}
+	q, args, err := cr.sqlbuilder.Update(table).Set("text", ucp.Text).
+		Where(squirrel.Eq{"comment_id": commentId, "deleted_at": nil}).
+		Suffix("RETURNING comment_id").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for UpdateComment: %w", err)
+	}
+	if err := cr.db.QueryRowx(q, args...).Scan(&commentId); err != nil {
+		return res, err
+	}
+	return cr.getComment(kind, commentId)
+}
+
+func (cr *CommentRepo) DeleteComment(kind string, commentId int64) error {
+	table, _, err := commentTable(kind)
+	if err != nil {
+		return err
+	}
+	q, args, err := cr.sqlbuilder.Update(table).Set("deleted_at", time.Now()).
+		Where(squirrel.Eq{"comment_id": commentId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for DeleteComment: %w", err)
+	}
+	_, err = cr.db.Exec(q, args...)
+	return err
+}
+
+type CommentStatus struct {
+	AuthorId  int64      `db:"comment_by"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	// id of the question, or the answer
+	ParentId int64 `db:"parent_id"`
+}
+
+func (cr *CommentRepo) GetCommentStatus(kind string, commentId int64) (CommentStatus, error) {
+	cs := CommentStatus{}
+	table, parentColumn, err := commentTable(kind)
+	if err != nil {
+		return cs, err
+	}
+	q, args, err := cr.sqlbuilder.Select("comment_by", "deleted_at", parentColumn+" AS parent_id").From(table).
+		Where(squirrel.Eq{"comment_id": commentId}).ToSql()
+	if err != nil {
+		return cs, fmt.Errorf("error while building query for GetCommentStatus: %w", err)
+	}
+	err = cr.db.QueryRowx(q, args...).StructScan(&cs)
+	return cs, err
+}
+
+func (cr *CommentRepo) GetComments(kind string, parentId int64) ([]CommentResponse, error) {
+	comments, err := getCommentsFor(cr.db, cr.sqlbuilder, kind, []int64{parentId})
+	if err != nil {
+		return []CommentResponse{}, err
+	}
+	return comments[parentId], nil
+}
+
+func (cr *CommentRepo) getComment(kind string, commentId int64) (CommentResponse, error) {
+	res := CommentResponse{}
+	b, err := commentsSelectBuilder(cr.sqlbuilder, kind)
+	if err != nil {
+		return res, err
+	}
+	query, args, err := b.Where(squirrel.Eq{"c.comment_id": commentId}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for getComment: %w", err)
+	}
+	err = cr.db.QueryRowx(query, args...).StructScan(&res)
+	return res, err
+}
+
+func commentsSelectBuilder(sqlbuilder squirrel.StatementBuilderType, kind string) (squirrel.SelectBuilder, error) {
+	table, parentColumn, err := commentTable(kind)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+	q := sqlbuilder.Select("c.comment_id", "c.text", "c.created_at", "c."+parentColumn+" AS parent_id",
+		`u.username AS "author.username"`, `u.handle AS "author.handle"`, `u.created_at AS "author.created_at"`, `u.reputation AS "author.reputation"`).
+		From(table + " c").
+		InnerJoin("users u ON u.user_id = c.comment_by")
+	return q, nil
+}
+
+// non-deleted comments of the given questions, or answers, oldest first. the map
+// has an entry (possibly an empty slice) for every id in parentIds.
+func getCommentsFor(db sqlx.Queryer, sqlbuilder squirrel.StatementBuilderType, kind string, parentIds []int64) (map[int64][]CommentResponse, error) {
+	res := map[int64][]CommentResponse{}
+	for _, id := range parentIds {
+		res[id] = []CommentResponse{}
+	}
+	if len(parentIds) == 0 {
+		return res, nil
+	}
+	_, parentColumn, err := commentTable(kind)
+	if err != nil {
+		return res, err
+	}
+	b, err := commentsSelectBuilder(sqlbuilder, kind)
+	if err != nil {
+		return res, err
+	}
+	q, args, err := b.Where(squirrel.Eq{"c." + parentColumn: parentIds, "c.deleted_at": nil}).
+		OrderBy("c.created_at ASC", "c.comment_id ASC").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for getCommentsFor: %w", err)
+	}
+	rows, err := db.Queryx(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cr CommentResponse
+		if err := rows.StructScan(&cr); err != nil {
+			return res, err
+		}
+		res[cr.ParentId] = append(res[cr.ParentId], cr)
+	}
+	return res, rows.Err()
+}
+
+func (cr *CommentRepo) RestoreComment(kind string, commentId int64) error {
+	table, _, err := commentTable(kind)
+	if err != nil {
+		return err
+	}
+	q, args, err := cr.sqlbuilder.Update(table).Set("deleted_at", nil).
+		Where(squirrel.Eq{"comment_id": commentId}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		Suffix("RETURNING comment_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RestoreComment: %w", err)
+	}
+	return cr.db.QueryRowx(q, args...).Scan(&commentId)
+}
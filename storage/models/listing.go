@@ -0,0 +1,175 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+const (
+	QUESTIONS_SORT_NEWEST     = "newest"
+	QUESTIONS_SORT_SCORE      = "score"
+	QUESTIONS_SORT_ANSWERS    = "answers"
+	QUESTIONS_SORT_UNANSWERED = "unanswered"
+)
+
+const (
+	ERROR_INVALID_SORT   = "invalid sort. need one of 'newest', 'score', 'answers', 'unanswered'"
+	ERROR_INVALID_CURSOR = "invalid cursor"
+)
+
+// a short form of a question, used in listings
+type QuestionSummaryResponse struct {
+	BasicUserResponse `json:"author" db:"author"`
+	QuestionId        int64      `json:"question_id" db:"question_id"`
+	Title             string     `json:"title" db:"title"`
+	CreatedAt         *time.Time `json:"created_at" db:"created_at"`
+	// upvotes - downvotes
+	Score       int64    `json:"score" db:"score"`
+	AnswerCount int64    `json:"answer_count" db:"answer_count"`
+	Tags        []string `json:"tags"`
+}
+
+type ListQuestionsParams struct {
+	Sort string
+	// empty strings mean no filtering
+	Tag, Author string
+	// NextCursor of the previous page. empty for the first page.
+	Cursor string
+	Limit  uint64
+}
+
+type ListQuestionsResponse struct {
+	Questions []QuestionSummaryResponse `json:"questions"`
+	// empty if this is the last page
+	NextCursor string `json:"next_cursor"`
+}
+
+// position of the last question of a page, in the sort order of the listing
+type questionsCursor struct {
+	Sort      string     `json:"s"`
+	CreatedAt *time.Time `json:"t,omitempty"`
+	Value     int64      `json:"v"`
+	Id        int64      `json:"id"`
+}
+
+func (qc questionsCursor) encode() string {
+	bx, _ := json.Marshal(qc)
+	return base64.RawURLEncoding.EncodeToString(bx)
+}
+
+func decodeQuestionsCursor(raw, sort string) (questionsCursor, error) {
+	qc := questionsCursor{}
+	bx, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return qc, errors.New(ERROR_INVALID_CURSOR)
+	}
+	if err := json.Unmarshal(bx, &qc); err != nil {
+		return qc, errors.New(ERROR_INVALID_CURSOR)
+	}
+	// a cursor is only meaningful in the order it was created in
+	if qc.Sort != sort {
+		return qc, errors.New(ERROR_INVALID_CURSOR)
+	}
+	// time sorts continue after a time, the others after a count
+	byTime := sort == QUESTIONS_SORT_NEWEST || sort == QUESTIONS_SORT_UNANSWERED
+	if byTime != (qc.CreatedAt != nil) {
+		return qc, errors.New(ERROR_INVALID_CURSOR)
+	}
+	return qc, nil
+}
+
+// non-deleted questions with their authors, scores, and answer counts
+func questionSummaryBuilder(sqlbuilder squirrel.StatementBuilderType) squirrel.SelectBuilder {
+	return sqlbuilder.Select("q.question_id", "q.title", "q.created_at",
+		`u.username AS "author.username"`, `u.handle AS "author.handle"`, `u.created_at AS "author.created_at"`, `u.reputation AS "author.reputation"`,
+		"(SELECT COUNT(*) FROM question_upvotes qu WHERE qu.question_id = q.question_id) - "+
+			"(SELECT COUNT(*) FROM question_downvotes qd WHERE qd.question_id = q.question_id) AS score",
+		"(SELECT COUNT(*) FROM answers a WHERE a.to_question = q.question_id AND a.deleted_at IS NULL) AS answer_count").
+		From("questions q").
+		InnerJoin("users u ON u.user_id = q.question_by").
+		Where(squirrel.Eq{"q.deleted_at": nil})
+}
+
+// keyset pagination: instead of an offset, every page continues after the cursor of the last
+// question of the previous page. so, a page doesn't shift when new questions are asked.
+func (qr *QuestionRepo) ListQuestions(params ListQuestionsParams) (ListQuestionsResponse, error) {
+	res := ListQuestionsResponse{Questions: []QuestionSummaryResponse{}}
+	inner := questionSummaryBuilder(qr.sqlbuilder)
+	if len(params.Tag) > 0 {
+		inner = inner.Where("EXISTS (SELECT 1 FROM question_tags qt INNER JOIN tags t ON t.tag_id = qt.tag_id "+
+			"WHERE qt.question_id = q.question_id AND t.tag = ?)", params.Tag)
+	}
+	if len(params.Author) > 0 {
+		inner = inner.Where(squirrel.Eq{"u.handle": params.Author})
+	}
+	// unanswered questions are listed newest first
+	keyColumn := ""
+	switch params.Sort {
+	case QUESTIONS_SORT_NEWEST, QUESTIONS_SORT_UNANSWERED:
+		keyColumn = "feed.created_at"
+	case QUESTIONS_SORT_SCORE:
+		keyColumn = "feed.score"
+	case QUESTIONS_SORT_ANSWERS:
+		keyColumn = "feed.answer_count"
+	default:
+		return res, errors.New(ERROR_INVALID_SORT)
+	}
+	// wrap the query, so that we can filter, and order by the computed columns
+	outer := qr.sqlbuilder.Select("feed.*").FromSelect(inner, "feed").
+		OrderBy(keyColumn+" DESC", "feed.question_id DESC").
+		// one more than needed, to know whether there is a next page
+		Limit(params.Limit + 1)
+	if params.Sort == QUESTIONS_SORT_UNANSWERED {
+		outer = outer.Where(squirrel.Eq{"feed.answer_count": 0})
+	}
+	if len(params.Cursor) > 0 {
+		cursor, err := decodeQuestionsCursor(params.Cursor, params.Sort)
+		if err != nil {
+			return res, err
+		}
+		var key interface{} = cursor.Value
+		if cursor.CreatedAt != nil {
+			key = *cursor.CreatedAt
+		}
+		outer = outer.Where("("+keyColumn+", feed.question_id) < (?, ?)", key, cursor.Id)
+	}
+	q, args, err := outer.ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for ListQuestions: %w", err)
+	}
+	rows, err := qr.db.Queryx(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var qsr QuestionSummaryResponse
+		if err := rows.StructScan(&qsr); err != nil {
+			return res, err
+		}
+		res.Questions = append(res.Questions, qsr)
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	if uint64(len(res.Questions)) > params.Limit {
+		res.Questions = res.Questions[:params.Limit]
+		last := res.Questions[len(res.Questions)-1]
+		cursor := questionsCursor{Sort: params.Sort, Id: last.QuestionId}
+		switch params.Sort {
+		case QUESTIONS_SORT_NEWEST, QUESTIONS_SORT_UNANSWERED:
+			cursor.CreatedAt = last.CreatedAt
+		case QUESTIONS_SORT_SCORE:
+			cursor.Value = last.Score
+		case QUESTIONS_SORT_ANSWERS:
+			cursor.Value = last.AnswerCount
+		}
+		res.NextCursor = cursor.encode()
+	}
+	return res, fillTagsForQuestions(qr.db, qr.sqlbuilder, res.Questions)
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+type PurgeRepository interface {
+	// hard-deletes the questions, answers, comments, and users soft-deleted before the time
+	PurgeDeleted(time.Time) (PurgeResult, error)
+}
+
+type PurgeRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewPurgeRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *PurgeRepo {
+	return &PurgeRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type PurgeResult struct {
+	Users, Questions, Answers, Comments int64
+}
+
+// the rows to purge are collected in temporary tables first:
+//   - users soft-deleted before the cutoff,
+//   - questions soft-deleted before the cutoff, and the questions of purged users,
+//   - answers soft-deleted before the cutoff, the answers of purged users, and the answers of
+//     purged questions.
+//
+// then everything that references them is deleted, in the order of the foreign keys.
+//
+// reputation events caused by, or received by purged users are deleted, and the reputation of
+// the remaining users is adjusted. events of purged posts of remaining users stay; the reputation
+// of a user doesn't change when their post is deleted. revisions edited by purged users stay,
+// without an editor.
+func (p *PurgeRepo) PurgeDeleted(before time.Time) (PurgeResult, error) {
+	res := PurgeResult{}
+	tx, err := p.db.Beginx()
+	if err != nil {
+		return res, err
+	}
+	collect := []string{
+		`INSERT INTO purge_users SELECT user_id FROM users WHERE deleted_at < $1`,
+		`INSERT INTO purge_questions SELECT question_id FROM questions
+			WHERE deleted_at < $1 OR question_by IN (SELECT user_id FROM purge_users)`,
+		`INSERT INTO purge_answers SELECT answer_id FROM answers
+			WHERE deleted_at < $1 OR answer_by IN (SELECT user_id FROM purge_users)
+			OR to_question IN (SELECT question_id FROM purge_questions)`,
+	}
+	for _, table := range []string{"purge_users (user_id int)", "purge_questions (question_id int)", "purge_answers (answer_id int)"} {
+		if _, err := tx.Exec("CREATE TEMPORARY TABLE " + table + " ON COMMIT DROP"); err != nil {
+			tx.Rollback()
+			return res, fmt.Errorf("*PurgeRepo.PurgeDeleted: %w", err)
+		}
+	}
+	for _, q := range collect {
+		if _, err := tx.Exec(q, before); err != nil {
+			tx.Rollback()
+			return res, fmt.Errorf("*PurgeRepo.PurgeDeleted: %w", err)
+		}
+	}
+	// the cutoff is $1 in the statements that use it. affected rows of the statements with a
+	// count are added to the result.
+	statements := []struct {
+		query  string
+		cutoff bool
+		count  *int64
+	}{
+		{`DELETE FROM comments_to_answer WHERE deleted_at < $1
+			OR to_answer IN (SELECT answer_id FROM purge_answers)
+			OR comment_by IN (SELECT user_id FROM purge_users)`, true, &res.Comments},
+		{`DELETE FROM comments_to_question WHERE deleted_at < $1
+			OR to_question IN (SELECT question_id FROM purge_questions)
+			OR comment_by IN (SELECT user_id FROM purge_users)`, true, &res.Comments},
+		{`DELETE FROM answer_upvotes WHERE (answer_id IN (SELECT answer_id FROM purge_answers)
+			OR upvote_by IN (SELECT user_id FROM purge_users))`, false, nil},
+		{`DELETE FROM answer_downvotes WHERE (answer_id IN (SELECT answer_id FROM purge_answers)
+			OR downvote_by IN (SELECT user_id FROM purge_users))`, false, nil},
+		{`DELETE FROM question_upvotes WHERE (question_id IN (SELECT question_id FROM purge_questions)
+			OR upvote_by IN (SELECT user_id FROM purge_users))`, false, nil},
+		{`DELETE FROM question_downvotes WHERE (question_id IN (SELECT question_id FROM purge_questions)
+			OR downvote_by IN (SELECT user_id FROM purge_users))`, false, nil},
+		{`DELETE FROM answer_revisions WHERE answer_id IN (SELECT answer_id FROM purge_answers)`, false, nil},
+		{`DELETE FROM question_revisions WHERE question_id IN (SELECT question_id FROM purge_questions)`, false, nil},
+		{`UPDATE answer_revisions SET edited_by = NULL WHERE edited_by IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`UPDATE question_revisions SET edited_by = NULL WHERE edited_by IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`DELETE FROM question_tags WHERE question_id IN (SELECT question_id FROM purge_questions)`, false, nil},
+		{`UPDATE questions SET accepted_answer = NULL WHERE accepted_answer IN (SELECT answer_id FROM purge_answers)`, false, nil},
+		{`UPDATE questions SET locked_by = NULL WHERE locked_by IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`UPDATE questions SET deleted_by = NULL WHERE deleted_by IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`UPDATE answers SET deleted_by = NULL WHERE deleted_by IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`DELETE FROM answers WHERE answer_id IN (SELECT answer_id FROM purge_answers)`, false, &res.Answers},
+		{`DELETE FROM questions WHERE question_id IN (SELECT question_id FROM purge_questions)`, false, &res.Questions},
+		// a reversal has the same user, and actor as the event it reverses, so both are deleted
+		{`WITH deleted AS (
+			DELETE FROM reputation_events WHERE (user_id IN (SELECT user_id FROM purge_users)
+			OR actor_id IN (SELECT user_id FROM purge_users))
+			RETURNING user_id, delta
+		)
+		UPDATE users u SET reputation = u.reputation - d.total
+		FROM (SELECT user_id, SUM(delta) AS total FROM deleted GROUP BY user_id) d
+		WHERE u.user_id = d.user_id`, false, nil},
+		{`DELETE FROM user_badges WHERE user_id IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`DELETE FROM refresh_tokens WHERE user_id IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`DELETE FROM api_keys WHERE user_id IN (SELECT user_id FROM purge_users)`, false, nil},
+		{`DELETE FROM users WHERE user_id IN (SELECT user_id FROM purge_users)`, false, &res.Users},
+	}
+	for _, s := range statements {
+		args := []interface{}{}
+		if s.cutoff {
+			args = append(args, before)
+		}
+		r, err := tx.Exec(s.query, args...)
+		if err != nil {
+			tx.Rollback()
+			return res, fmt.Errorf("*PurgeRepo.PurgeDeleted: %w", err)
+		}
+		if s.count == nil {
+			continue
+		}
+		n, err := r.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return res, err
+		}
+		*s.count += n
+	}
+	return res, tx.Commit()
+}
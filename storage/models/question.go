@@ -16,10 +16,20 @@ type QuestionRepository interface {
 	NewQuestion(*NewQuestionPayload) (NewQuestionResponse, error)
 	GetQuestion(int64) (ViewQuestionResponse, error)
 	UpdateQuestion(int64, *UpdateQuestionPayload) (UpdateQuestionResponse, error)
-	DeleteQuestion(int64) error
+	// questionId, deletedBy
+	DeleteQuestion(int64, int64) error
 	GetQuestionStatus(int64) (QuestionStatus, error)
-	UpvoteQuestion(int64, int64) error
-	DownvoteQuestion(int64, int64) error
+	// questionId, userId -> current vote of the user, err
+	UpvoteQuestion(int64, int64) (string, error)
+	DownvoteQuestion(int64, int64) (string, error)
+	RetractQuestionVote(int64, int64) (string, error)
+	// questionId, answerId. a nil answerId unaccepts the accepted answer.
+	SetAcceptedAnswer(int64, *int64) error
+	ListQuestions(ListQuestionsParams) (ListQuestionsResponse, error)
+	// questionId, lockedBy. a nil lockedBy unlocks the question.
+	SetQuestionLock(int64, *int64) error
+	// returns sql.ErrNoRows if the question is not deleted
+	RestoreQuestion(int64) error
 }
 
 type QuestionRepo struct {
@@ -34,10 +44,16 @@ func NewQuestionRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *QuestionRepo {
 	}
 }
 
+// NewQuestion fails with this if the user asked DailyLimit questions in the last 24 hours
+const ERROR_QUESTIONS_PER_DAY = "too many questions in the last 24 hours"
+
 type NewQuestionPayload struct {
-	UserId int64  // set this from request context's user id
-	Title  string `json:"title"`
-	Text   string `json:"text"`
+	UserId int64    // set this from request context's user id
+	Title  string   `json:"title"`
+	Text   string   `json:"text"`
+	Tags   []string `json:"tags"`
+	// questions the user can ask in 24 hours, deleted ones too. 0 is no limit.
+	DailyLimit int64 `json:"-"`
 }
 
 func (nqp *NewQuestionPayload) Okay() (okay.ValidationErrors, error) {
@@ -52,10 +68,18 @@ type NewQuestionResponse struct {
 	Title      string     `db:"title" json:"title"`
 	Text       string     `db:"text" json:"text"`
 	CreatedAt  *time.Time `db:"created_at" json:"created_at"`
+	Tags       []string   `json:"tags"`
 }
 
+// also normalizes the tags
 func (nqp *NewQuestionPayload) Validate() ([]string, error) {
-	return okay.Validate(nqp)
+	errs, err := okay.Validate(nqp)
+	if err != nil {
+		return errs, err
+	}
+	tags, tagErrs := normalizeTags(nqp.Tags)
+	nqp.Tags = tags
+	return append(errs, tagErrs...), nil
 }
 
 func (qr *QuestionRepo) NewQuestion(payload *NewQuestionPayload) (NewQuestionResponse, error) {
@@ -73,14 +97,46 @@ func (qr *QuestionRepo) NewQuestion(payload *NewQuestionPayload) (NewQuestionRes
 	if err != nil {
 		return res, errors.New("could not begin a new transaction")
 	}
+	if payload.DailyLimit > 0 {
+		if err := checkDailyLimit(tx, questionBy, payload.DailyLimit); err != nil {
+			tx.Rollback()
+			return res, err
+		}
+	}
 	row := tx.QueryRowx(q, args...)
 	err = row.StructScan(&res)
 	if err != nil {
 		tx.Rollback()
 		return res, err
 	}
-	tx.Commit()
-	return res, nil
+	res.Tags = []string{}
+	if len(payload.Tags) > 0 {
+		if err := setTagsForQuestion(tx, qr.sqlbuilder, res.QuestionId, payload.Tags); err != nil {
+			tx.Rollback()
+			return res, err
+		}
+		res.Tags = payload.Tags
+	}
+	return res, tx.Commit()
+}
+
+// the row of the user is locked until the transaction ends, so that concurrent questions of the
+// user are counted one after the other, and can't all pass
+func checkDailyLimit(tx *sqlx.Tx, userId, limit int64) error {
+	var locked int64
+	if err := tx.QueryRowx("SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE", userId).Scan(&locked); err != nil {
+		return err
+	}
+	var asked int64
+	err := tx.QueryRowx("SELECT COUNT(*) FROM questions WHERE question_by = $1 AND created_at > now() - interval '1 day'", userId).
+		Scan(&asked)
+	if err != nil {
+		return err
+	}
+	if asked >= limit {
+		return errors.New(ERROR_QUESTIONS_PER_DAY)
+	}
+	return nil
 }
 
 type ViewQuestionResponse struct {
@@ -89,10 +145,14 @@ type ViewQuestionResponse struct {
 	Title             string                `json:"title" db:"title"`
 	Text              string                `json:"text" db:"text"`
 	CreatedAt         *time.Time            `json:"created_at" db:"created_at"`
+	AcceptedAnswerId  *int64                `json:"accepted_answer_id" db:"accepted_answer"`
+	LockedAt          *time.Time            `json:"locked_at" db:"locked_at"`
+	Version           int64                 `json:"version" db:"version"`
 	UpvoteCount       uint64                `json:"upvotes"`
 	DownvoteCount     uint64                `json:"downvotes"`
 	Answers           []BasicAnswerResponse `json:"answers"`
 	Tags              []string              `json:"tags"`
+	Comments          []CommentResponse     `json:"comments"`
 }
 
 func (qr *QuestionRepo) GetQuestion(questionId int64) (ViewQuestionResponse, error) {
@@ -114,6 +174,11 @@ func (qr *QuestionRepo) GetQuestion(questionId int64) (ViewQuestionResponse, err
 		return res, err
 	}
 	res.Answers = answers
+	comments, err := getCommentsFor(qr.db, qr.sqlbuilder, COMMENT_TO_QUESTION, []int64{questionId})
+	if err != nil {
+		return res, err
+	}
+	res.Comments = comments[questionId]
 	upvotes, downvotes, err := qr.getUpvoteAndDownvotesForQuestion(questionId)
 	if err != nil {
 		return res, err
@@ -121,7 +186,7 @@ func (qr *QuestionRepo) GetQuestion(questionId int64) (ViewQuestionResponse, err
 	res.UpvoteCount = upvotes
 	res.DownvoteCount = downvotes
 	q, args, err := qr.sqlbuilder.Select("q.question_id", "q.title", "q.text", "q.created_at",
-		"u.username", "u.handle", "u.created_at").
+		"q.accepted_answer", "q.locked_at", "q.version", "u.username", "u.handle", "u.created_at", "u.reputation").
 		From("questions q").
 		InnerJoin("users u ON u.user_id = q.question_by").
 		Where(squirrel.Eq{"q.question_id": questionId}).
@@ -139,8 +204,10 @@ func (qr *QuestionRepo) GetQuestion(questionId int64) (ViewQuestionResponse, err
 
 func (qr *QuestionRepo) getTagsForQuestion(questionId int64) ([]string, error) {
 	res := []string{}
-	tagsQuery, tagsQueryArgs, err := qr.sqlbuilder.Select("DISTINCT t.tag").From("tags t").
-		InnerJoin("question_tags qt ON qt.question_id = $1", questionId).
+	tagsQuery, tagsQueryArgs, err := qr.sqlbuilder.Select("t.tag").From("tags t").
+		InnerJoin("question_tags qt ON qt.tag_id = t.tag_id").
+		Where(squirrel.Eq{"qt.question_id": questionId}).
+		OrderBy("t.tag").
 		ToSql()
 	if err != nil {
 		return res, err
@@ -149,6 +216,7 @@ func (qr *QuestionRepo) getTagsForQuestion(questionId int64) ([]string, error) {
 	if err != nil {
 		return res, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		var tag string
 		err = rows.Scan(&tag)
@@ -157,15 +225,15 @@ func (qr *QuestionRepo) getTagsForQuestion(questionId int64) ([]string, error) {
 		}
 		res = append(res, tag)
 	}
-	return res, nil
+	return res, rows.Err()
 }
 
 func (qr *QuestionRepo) getAnswersForQuestion(questionId int64) ([]BasicAnswerResponse, error) {
 	res := []BasicAnswerResponse{}
-	q, args, err := qr.sqlbuilder.Select("a.answer_id", "u.username", "u.handle", "u.created_at",
-		"a.text", "a.created_at").
-		From("answers a").InnerJoin("users u ON a.answer_by = u.user_id").
+	q, args, err := basicAnswerBuilder(qr.sqlbuilder).
 		Where(squirrel.Eq{"a.to_question": questionId}).
+		// the accepted answer comes first
+		OrderBy("is_accepted DESC", "a.created_at ASC").
 		ToSql()
 	if err != nil {
 		return res, err
@@ -174,6 +242,8 @@ func (qr *QuestionRepo) getAnswersForQuestion(questionId int64) ([]BasicAnswerRe
 	if err != nil {
 		return res, err
 	}
+	defer rows.Close()
+	answerIds := []int64{}
 	for rows.Next() {
 		var answer BasicAnswerResponse
 		err = rows.StructScan(&answer)
@@ -181,6 +251,17 @@ func (qr *QuestionRepo) getAnswersForQuestion(questionId int64) ([]BasicAnswerRe
 			return res, err
 		}
 		res = append(res, answer)
+		answerIds = append(answerIds, answer.AnswerId)
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	comments, err := getCommentsFor(qr.db, qr.sqlbuilder, COMMENT_TO_ANSWER, answerIds)
+	if err != nil {
+		return res, err
+	}
+	for i := range res {
+		res[i].Comments = comments[res[i].AnswerId]
 	}
 	return res, nil
 }
@@ -213,21 +294,38 @@ func (qr *QuestionRepo) getUpvoteAndDownvotesForQuestion(questionId int64) (uint
 type UpdateQuestionPayload struct {
 	Title string `json:"title"`
 	Text  string `json:"text"`
+	// nil leaves the tags as they are, an empty list removes all of them
+	Tags []string `json:"tags"`
+	// edit summary of the revision
+	Summary  string `json:"summary"`
+	EditedBy int64  `json:"-"` // set this from request context's user id
+	// the update fails with ERROR_VERSION_MISMATCH if the stored version is different. 0 skips
+	// the check.
+	IfVersion int64 `json:"-"`
 }
 
 func (uqp *UpdateQuestionPayload) Okay() (okay.ValidationErrors, error) {
 	o := okay.New()
 	o.Text(uqp.Title, "title").Required()
 	o.Text(uqp.Text, "text").Required()
+	o.Text(uqp.Summary, "summary").MaxLength(MAX_EDIT_SUMMARY_LENGTH)
 	return o.Errors()
 }
 
+// also normalizes the tags
 func (u *UpdateQuestionPayload) Validate() ([]string, error) {
-	return okay.Validate(u)
+	errs, err := okay.Validate(u)
+	if err != nil {
+		return errs, err
+	}
+	tags, tagErrs := normalizeTags(u.Tags)
+	u.Tags = tags
+	return append(errs, tagErrs...), nil
 }
 
 type UpdateQuestionResponse struct {
 	QuestionId int64 `json:"question_id" db:"question_id"`
+	Version    int64 `json:"version" db:"version"`
 	UpdateQuestionPayload
 }
 
@@ -240,7 +338,7 @@ func (qr *QuestionRepo) UpdateQuestion(questionId int64, uqp *UpdateQuestionPayl
 	if len(uqp.Title) > 0 {
 		whichFields = append(whichFields, "title")
 	}
-	updateBuilder := qr.sqlbuilder.Update("questions")
+	updateBuilder := qr.sqlbuilder.Update("questions").Set("version", squirrel.Expr("version + 1"))
 	for _, v := range whichFields {
 		switch v {
 		case "text":
@@ -255,23 +353,60 @@ func (qr *QuestionRepo) UpdateQuestion(questionId int64, uqp *UpdateQuestionPayl
 			"question_id": questionId,
 			"deleted_at":  nil,
 		}).
-		Suffix("RETURNING question_id, title, text").ToSql()
+		Suffix("RETURNING question_id, title, text, version").ToSql()
 	if err != nil {
 		return res, err
 	}
-	row := qr.db.QueryRowx(q, args...)
+	tx, err := qr.db.Beginx()
+	if err != nil {
+		return res, errors.New("could not begin a new transaction")
+	}
+	// sql.ErrNoRows if the question is deleted
+	version, err := ensureBaseRevision(tx, qr.sqlbuilder, "question", questionId)
+	if err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	// the question is locked, so the version can't change until the update
+	if uqp.IfVersion > 0 && uqp.IfVersion != version {
+		tx.Rollback()
+		return res, errors.New(ERROR_VERSION_MISMATCH)
+	}
+	row := tx.QueryRowx(q, args...)
 	err = row.StructScan(&res)
+	if err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	content := revisionContent{Title: res.Title, Text: res.Text}
+	if err := addRevision(tx, qr.sqlbuilder, "question", questionId, uqp.EditedBy, content, uqp.Summary); err != nil {
+		tx.Rollback()
+		return res, err
+	}
+	if uqp.Tags != nil {
+		if err := setTagsForQuestion(tx, qr.sqlbuilder, questionId, uqp.Tags); err != nil {
+			tx.Rollback()
+			return res, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+	res.Tags, err = qr.getTagsForQuestion(questionId)
 	return res, err
 }
 
 type QuestionStatus struct {
-	AuthorId  int64      `db:"question_by"`
-	DeletedAt *time.Time `db:"deleted_at"`
+	AuthorId       int64      `db:"question_by"`
+	DeletedAt      *time.Time `db:"deleted_at"`
+	AcceptedAnswer *int64     `db:"accepted_answer"`
+	LockedAt       *time.Time `db:"locked_at"`
+	DeletedBy      *int64     `db:"deleted_by"`
 }
 
 func (qr *QuestionRepo) GetQuestionStatus(questionId int64) (QuestionStatus, error) {
 	var qs QuestionStatus
-	q, args, err := qr.sqlbuilder.Select("question_by", "deleted_at").From("questions").
+	q, args, err := qr.sqlbuilder.Select("question_by", "deleted_at", "accepted_answer", "locked_at", "deleted_by").From("questions").
 		Where(squirrel.Eq{"question_id": questionId}).Limit(1).ToSql()
 	if err != nil {
 		return qs, err
@@ -281,9 +416,10 @@ func (qr *QuestionRepo) GetQuestionStatus(questionId int64) (QuestionStatus, err
 	return qs, err
 }
 
-func (qr *QuestionRepo) DeleteQuestion(questionId int64) error {
+func (qr *QuestionRepo) DeleteQuestion(questionId, deletedBy int64) error {
 	q, args, err := qr.sqlbuilder.Update("questions").
 		Set("deleted_at", time.Now()).
+		Set("deleted_by", deletedBy).
 		Where(squirrel.Eq{
 			"question_id": questionId,
 		}).ToSql()
@@ -297,27 +433,145 @@ func (qr *QuestionRepo) DeleteQuestion(questionId int64) error {
 const (
 	ERROR_UPVOTE_OWN_QUESTION   = "cannot upvote own question"
 	ERROR_DOWNVOTE_OWN_QUESTION = "cannot downvote own question"
+	ERROR_QUESTION_LOCKED       = "question is locked"
 )
 
-func (qr *QuestionRepo) UpvoteQuestion(questionId, upvoteBy int64) error {
+func (qr *QuestionRepo) UpvoteQuestion(questionId, upvoteBy int64) (string, error) {
 	// can't upvote own question
 	qs, err := qr.GetQuestionStatus(questionId)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if qs.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if qs.LockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
 	}
 	if qs.AuthorId == upvoteBy {
-		return fmt.Errorf(ERROR_UPVOTE_OWN_QUESTION)
+		return "", fmt.Errorf(ERROR_UPVOTE_OWN_QUESTION)
 	}
-	return voteQuestion(qr, "upvote", questionId, upvoteBy)
+	return castVote(qr.db, qr.sqlbuilder, "question", VOTE_UPVOTE, questionId, upvoteBy)
 }
 
-func (qr *QuestionRepo) DownvoteQuestion(questionId, downvoteBy int64) error {
+func (qr *QuestionRepo) DownvoteQuestion(questionId, downvoteBy int64) (string, error) {
 	qs, err := qr.GetQuestionStatus(questionId)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if qs.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if qs.LockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
 	}
 	if qs.AuthorId == downvoteBy {
-		return fmt.Errorf(ERROR_DOWNVOTE_OWN_QUESTION)
+		return "", fmt.Errorf(ERROR_DOWNVOTE_OWN_QUESTION)
+	}
+	return castVote(qr.db, qr.sqlbuilder, "question", VOTE_DOWNVOTE, questionId, downvoteBy)
+}
+
+func (qr *QuestionRepo) RetractQuestionVote(questionId, voteBy int64) (string, error) {
+	qs, err := qr.GetQuestionStatus(questionId)
+	if err != nil {
+		return "", err
+	}
+	if qs.DeletedAt != nil {
+		return "", sql.ErrNoRows
+	}
+	if qs.LockedAt != nil {
+		return "", errors.New(ERROR_QUESTION_LOCKED)
+	}
+	return retractVote(qr.db, qr.sqlbuilder, "question", questionId, voteBy)
+}
+
+const ERROR_ANSWER_NOT_OF_QUESTION = "answer does not belong to this question"
+
+// the author of the accepted answer gains reputation, unless they are the author of the question.
+// the author of the previously accepted answer loses it.
+func (qr *QuestionRepo) SetAcceptedAnswer(questionId int64, answerId *int64) error {
+	var answerBy int64
+	if answerId != nil {
+		q, args, err := qr.sqlbuilder.Select("to_question", "answer_by").From("answers").
+			Where(squirrel.Eq{"answer_id": *answerId, "deleted_at": nil}).ToSql()
+		if err != nil {
+			return err
+		}
+		var toQuestion int64
+		if err := qr.db.QueryRowx(q, args...).Scan(&toQuestion, &answerBy); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New(ERROR_ANSWER_NOT_OF_QUESTION)
+			}
+			return err
+		}
+		if toQuestion != questionId {
+			return errors.New(ERROR_ANSWER_NOT_OF_QUESTION)
+		}
+	}
+	q, args, err := qr.sqlbuilder.Select("question_by", "accepted_answer").From("questions").
+		Where(squirrel.Eq{"question_id": questionId, "deleted_at": nil}).
+		Suffix("FOR UPDATE").ToSql()
+	if err != nil {
+		return err
+	}
+	tx, err := qr.db.Beginx()
+	if err != nil {
+		return err
+	}
+	var questionBy int64
+	var previous *int64
+	if err := tx.QueryRowx(q, args...).Scan(&questionBy, &previous); err != nil {
+		tx.Rollback()
+		return err
+	}
+	changed := (previous == nil) != (answerId == nil) || (previous != nil && *previous != *answerId)
+	if previous != nil && changed {
+		if err := revertReputationEvent(tx, qr.sqlbuilder, questionBy, REPUTATION_ANSWER_ACCEPTED, "answer", *previous); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	q, args, err = qr.sqlbuilder.Update("questions").Set("accepted_answer", answerId).
+		Where(squirrel.Eq{"question_id": questionId}).ToSql()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if answerId != nil && changed && answerBy != questionBy {
+		if err := addReputationEvent(tx, qr.sqlbuilder, answerBy, questionBy, REPUTATION_ANSWER_ACCEPTED, "answer", *answerId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (qr *QuestionRepo) SetQuestionLock(questionId int64, lockedBy *int64) error {
+	b := qr.sqlbuilder.Update("questions").Set("locked_by", lockedBy)
+	if lockedBy == nil {
+		b = b.Set("locked_at", nil)
+	} else {
+		b = b.Set("locked_at", time.Now())
+	}
+	q, args, err := b.Where(squirrel.Eq{"question_id": questionId, "deleted_at": nil}).
+		Suffix("RETURNING question_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for SetQuestionLock: %w", err)
+	}
+	return qr.db.QueryRowx(q, args...).Scan(&questionId)
+}
+
+func (qr *QuestionRepo) RestoreQuestion(questionId int64) error {
+	q, args, err := qr.sqlbuilder.Update("questions").Set("deleted_at", nil).Set("deleted_by", nil).
+		Where(squirrel.Eq{"question_id": questionId}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		Suffix("RETURNING question_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RestoreQuestion: %w", err)
 	}
-	return voteQuestion(qr, "downvote", questionId, downvoteBy)
+	return qr.db.QueryRowx(q, args...).Scan(&questionId)
 }
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+// only hashes of refresh tokens go in, and out of this repository
+type RefreshTokenRepository interface {
+	// userId, token hash, expires at. starts a new token family (a new session).
+	SaveRefreshToken(int64, string, time.Time) error
+	// old token hash, new token hash, new token expires at -> user id, err
+	RotateRefreshToken(string, string, time.Time) (int64, error)
+	// token hash. revokes the whole family of the token.
+	RevokeRefreshToken(string) error
+	// userId. log out everywhere.
+	RevokeAllRefreshTokens(int64) error
+}
+
+type RefreshTokenRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewRefreshTokenRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *RefreshTokenRepo {
+	return &RefreshTokenRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+const (
+	ERROR_REFRESH_TOKEN_INVALID = "invalid refresh token"
+	ERROR_REFRESH_TOKEN_EXPIRED = "refresh token expired"
+	// a refresh token that was already rotated, or revoked is used again. either the client is
+	// buggy, or the token is stolen. we can't tell which one of the two parties is the attacker,
+	// so the whole family is revoked.
+	ERROR_REFRESH_TOKEN_REUSED = "refresh token reused"
+)
+
+type refreshTokenStatus struct {
+	TokenId   int64      `db:"token_id"`
+	UserId    int64      `db:"user_id"`
+	Family    string     `db:"family"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+	// deleted_at of the owner of the token
+	UserDeletedAt *time.Time `db:"user_deleted_at"`
+}
+
+func (r *RefreshTokenRepo) SaveRefreshToken(userId int64, tokenHash string, expiresAt time.Time) error {
+	q, args, err := r.sqlbuilder.Insert("refresh_tokens").
+		Columns("token_hash", "family", "user_id", "expires_at").
+		Values(tokenHash, tokenHash, userId, expiresAt).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for SaveRefreshToken: %w", err)
+	}
+	_, err = r.db.Exec(q, args...)
+	return err
+}
+
+func (r *RefreshTokenRepo) RotateRefreshToken(oldHash, newHash string, expiresAt time.Time) (int64, error) {
+	q, args, err := r.sqlbuilder.Select("rt.token_id", "rt.user_id", "rt.family", "rt.expires_at", "rt.revoked_at",
+		"u.deleted_at AS user_deleted_at").
+		From("refresh_tokens rt").
+		InnerJoin("users u ON u.user_id = rt.user_id").
+		Where(squirrel.Eq{"rt.token_hash": oldHash}).
+		// concurrent rotations of the same token wait for each other. the second one sees the
+		// token as revoked.
+		Suffix("FOR UPDATE OF rt").ToSql()
+	if err != nil {
+		return -1, fmt.Errorf("error while building query for RotateRefreshToken: %w", err)
+	}
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return -1, err
+	}
+	var rts refreshTokenStatus
+	if err := tx.QueryRowx(q, args...).StructScan(&rts); err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	if rts.UserDeletedAt != nil {
+		tx.Rollback()
+		return -1, errors.New(ERROR_REFRESH_TOKEN_INVALID)
+	}
+	if rts.RevokedAt != nil {
+		if err := revokeFamily(tx, r.sqlbuilder, rts.Family); err != nil {
+			tx.Rollback()
+			return -1, err
+		}
+		if err := tx.Commit(); err != nil {
+			return -1, err
+		}
+		return -1, errors.New(ERROR_REFRESH_TOKEN_REUSED)
+	}
+	if time.Now().After(rts.ExpiresAt) {
+		tx.Rollback()
+		return -1, errors.New(ERROR_REFRESH_TOKEN_EXPIRED)
+	}
+	q, args, err = r.sqlbuilder.Insert("refresh_tokens").
+		Columns("token_hash", "family", "user_id", "expires_at").
+		Values(newHash, rts.Family, rts.UserId, expiresAt).
+		Suffix("RETURNING token_id").ToSql()
+	if err != nil {
+		tx.Rollback()
+		return -1, fmt.Errorf("error while building query for RotateRefreshToken: %w", err)
+	}
+	var newTokenId int64
+	if err := tx.QueryRowx(q, args...).Scan(&newTokenId); err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	q, args, err = r.sqlbuilder.Update("refresh_tokens").
+		Set("revoked_at", time.Now()).
+		Set("replaced_by", newTokenId).
+		Where(squirrel.Eq{"token_id": rts.TokenId}).ToSql()
+	if err != nil {
+		tx.Rollback()
+		return -1, fmt.Errorf("error while building query for RotateRefreshToken: %w", err)
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	return rts.UserId, tx.Commit()
+}
+
+func (r *RefreshTokenRepo) RevokeRefreshToken(tokenHash string) error {
+	q, args, err := r.sqlbuilder.Select("family").From("refresh_tokens").
+		Where(squirrel.Eq{"token_hash": tokenHash}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RevokeRefreshToken: %w", err)
+	}
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	var family string
+	if err := tx.QueryRowx(q, args...).Scan(&family); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := revokeFamily(tx, r.sqlbuilder, family); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *RefreshTokenRepo) RevokeAllRefreshTokens(userId int64) error {
+	q, args, err := r.sqlbuilder.Update("refresh_tokens").Set("revoked_at", time.Now()).
+		Where(squirrel.Eq{"user_id": userId, "revoked_at": nil}).ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RevokeAllRefreshTokens: %w", err)
+	}
+	_, err = r.db.Exec(q, args...)
+	return err
+}
+
+func revokeFamily(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, family string) error {
+	q, args, err := sqlbuilder.Update("refresh_tokens").Set("revoked_at", time.Now()).
+		Where(squirrel.Eq{"family": family, "revoked_at": nil}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(q, args...)
+	return err
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+// why the reputation of a user changed
+const (
+	REPUTATION_QUESTION_UPVOTED   = "question_upvoted"
+	REPUTATION_QUESTION_DOWNVOTED = "question_downvoted"
+	REPUTATION_ANSWER_UPVOTED     = "answer_upvoted"
+	REPUTATION_ANSWER_DOWNVOTED   = "answer_downvoted"
+	REPUTATION_ANSWER_ACCEPTED    = "answer_accepted"
+)
+
+var reputationDeltas = map[string]int64{
+	REPUTATION_QUESTION_UPVOTED:   5,
+	REPUTATION_QUESTION_DOWNVOTED: -2,
+	REPUTATION_ANSWER_UPVOTED:     10,
+	REPUTATION_ANSWER_DOWNVOTED:   -2,
+	REPUTATION_ANSWER_ACCEPTED:    15,
+}
+
+// the reputation of a user is the sum of the deltas of their events. users.reputation caches the
+// sum, and is updated in the same transaction as the events.
+type ReputationRepository interface {
+	// userId, limit, offset. the newest events come first.
+	GetReputationHistory(int64, uint64, uint64) (ReputationHistoryResponse, error)
+}
+
+type ReputationRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewReputationRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *ReputationRepo {
+	return &ReputationRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type ReputationEventResponse struct {
+	EventId int64  `json:"event_id" db:"event_id"`
+	Reason  string `json:"reason" db:"reason"`
+	Delta   int64  `json:"delta" db:"delta"`
+	// "question", or "answer"
+	PostType  string     `json:"post_type" db:"post_type"`
+	PostId    int64      `json:"post_id" db:"post_id"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	// set if this event undoes an earlier one. a retracted vote, or an unaccepted answer.
+	Reverses *int64 `json:"reverses,omitempty" db:"reverses"`
+}
+
+type ReputationHistoryResponse struct {
+	Reputation int64                     `json:"reputation"`
+	Events     []ReputationEventResponse `json:"events"`
+}
+
+// returns sql.ErrNoRows if there is no such user
+func (r *ReputationRepo) GetReputationHistory(userId int64, limit, offset uint64) (ReputationHistoryResponse, error) {
+	res := ReputationHistoryResponse{Events: []ReputationEventResponse{}}
+	q, args, err := r.sqlbuilder.Select("reputation").From("users").
+		Where(squirrel.Eq{"user_id": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetReputationHistory: %w", err)
+	}
+	if err := r.db.QueryRowx(q, args...).Scan(&res.Reputation); err != nil {
+		return res, err
+	}
+	q, args, err = r.sqlbuilder.Select("event_id", "reason", "delta", "post_type", "post_id", "created_at", "reverses").
+		From("reputation_events").
+		Where(squirrel.Eq{"user_id": userId}).
+		OrderBy("event_id DESC").
+		Limit(limit).Offset(offset).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetReputationHistory: %w", err)
+	}
+	err = r.db.Select(&res.Events, q, args...)
+	return res, err
+}
+
+// reason of a vote on a post
+func voteReputationReason(post, type_ string) (string, error) {
+	switch {
+	case post == "question" && type_ == VOTE_UPVOTE:
+		return REPUTATION_QUESTION_UPVOTED, nil
+	case post == "question" && type_ == VOTE_DOWNVOTE:
+		return REPUTATION_QUESTION_DOWNVOTED, nil
+	case post == "answer" && type_ == VOTE_UPVOTE:
+		return REPUTATION_ANSWER_UPVOTED, nil
+	case post == "answer" && type_ == VOTE_DOWNVOTE:
+		return REPUTATION_ANSWER_DOWNVOTED, nil
+	}
+	return "", fmt.Errorf("models.voteReputationReason: invalid post type '%s', or vote type '%s'", post, type_)
+}
+
+// post is either "question", or "answer"
+func postAuthor(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, post string, postId int64) (int64, error) {
+	b := squirrel.SelectBuilder{}
+	switch post {
+	case "question":
+		b = sqlbuilder.Select("question_by").From("questions").Where(squirrel.Eq{"question_id": postId})
+	case "answer":
+		b = sqlbuilder.Select("answer_by").From("answers").Where(squirrel.Eq{"answer_id": postId})
+	default:
+		return -1, fmt.Errorf("models.postAuthor: invalid post type '%s'", post)
+	}
+	q, args, err := b.ToSql()
+	if err != nil {
+		return -1, err
+	}
+	var authorId int64
+	err = tx.QueryRowx(q, args...).Scan(&authorId)
+	return authorId, err
+}
+
+// actorId is the user who caused the event. the voter, or the user who accepted the answer.
+func addReputationEvent(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, userId, actorId int64, reason, post string, postId int64) error {
+	delta, ok := reputationDeltas[reason]
+	if !ok {
+		return fmt.Errorf("models.addReputationEvent: invalid reason '%s'", reason)
+	}
+	q, args, err := sqlbuilder.Insert("reputation_events").
+		Columns("user_id", "actor_id", "reason", "delta", "post_type", "post_id").
+		Values(userId, actorId, reason, delta, post, postId).ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+	return addReputation(tx, sqlbuilder, userId, delta)
+}
+
+// undo the last event of the actor with the reason on the post, if it's not already undone.
+// the event stays in the history, and a new event with the opposite delta is added.
+func revertReputationEvent(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, actorId int64, reason, post string, postId int64) error {
+	q, args, err := sqlbuilder.Select("e.event_id", "e.user_id", "e.delta").From("reputation_events e").
+		Where(squirrel.Eq{"e.actor_id": actorId, "e.reason": reason, "e.post_type": post, "e.post_id": postId, "e.reverses": nil}).
+		Where("NOT EXISTS (SELECT 1 FROM reputation_events r WHERE r.reverses = e.event_id)").
+		OrderBy("e.event_id DESC").Limit(1).ToSql()
+	if err != nil {
+		return err
+	}
+	var eventId, userId, delta int64
+	if err := tx.QueryRowx(q, args...).Scan(&eventId, &userId, &delta); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	q, args, err = sqlbuilder.Insert("reputation_events").
+		Columns("user_id", "actor_id", "reason", "delta", "post_type", "post_id", "reverses").
+		Values(userId, actorId, reason, -delta, post, postId, eventId).ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+	return addReputation(tx, sqlbuilder, userId, -delta)
+}
+
+func addReputation(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, userId, delta int64) error {
+	q, args, err := sqlbuilder.Update("users").Set("reputation", squirrel.Expr("reputation + ?", delta)).
+		Where(squirrel.Eq{"user_id": userId}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(q, args...)
+	return err
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+const MAX_EDIT_SUMMARY_LENGTH = 300
+
+// an update with an outdated version. see If-Match.
+const ERROR_VERSION_MISMATCH = "post was changed since it was read"
+
+// revisions are recorded by *QuestionRepo.UpdateQuestion, and *AnswerRepo.UpdateAnswer
+type RevisionRepository interface {
+	// post is "question", or "answer". the oldest revision comes first.
+	GetRevisions(string, int64) ([]RevisionResponse, error)
+	// post, postId, revision. returns sql.ErrNoRows if there is no such revision.
+	GetRevision(string, int64, int64) (RevisionResponse, error)
+}
+
+type RevisionRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewRevisionRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *RevisionRepo {
+	return &RevisionRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+type RevisionResponse struct {
+	Revision int64             `json:"revision" db:"revision"`
+	EditedBy BasicUserResponse `json:"edited_by" db:"editor"`
+	// nil for answers
+	Title     *string    `json:"title,omitempty" db:"title"`
+	Text      string     `json:"text" db:"text"`
+	Summary   string     `json:"summary" db:"summary"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	// unified diff from the previous revision. set by the handler.
+	Diff string `json:"diff"`
+}
+
+// table, post id column, and the columns of the content
+func revisionTables(post string) (string, string, []string, error) {
+	switch post {
+	case "question":
+		return "question_revisions", "question_id", []string{"title", "text"}, nil
+	case "answer":
+		return "answer_revisions", "answer_id", []string{"text"}, nil
+	}
+	return "", "", nil, fmt.Errorf("models.revisionTables: invalid post type '%s'", post)
+}
+
+func revisionsBuilder(sqlbuilder squirrel.StatementBuilderType, post string, postId int64) (squirrel.SelectBuilder, error) {
+	table, idColumn, columns, err := revisionTables(post)
+	if err != nil {
+		return squirrel.SelectBuilder{}, err
+	}
+	title := "NULL AS title"
+	if len(columns) == 2 {
+		title = "r.title"
+	}
+	// the editor is empty if they are purged
+	return sqlbuilder.Select("r.revision", title, "r.text", "r.summary", "r.created_at",
+		`COALESCE(u.username, '') AS "editor.username"`, `COALESCE(u.handle, '') AS "editor.handle"`,
+		`u.created_at AS "editor.created_at"`, `COALESCE(u.reputation, 0) AS "editor.reputation"`).
+		From(table + " r").
+		LeftJoin("users u ON u.user_id = r.edited_by").
+		Where(squirrel.Eq{"r." + idColumn: postId}), nil
+}
+
+func (r *RevisionRepo) GetRevisions(post string, postId int64) ([]RevisionResponse, error) {
+	res := []RevisionResponse{}
+	sb, err := revisionsBuilder(r.sqlbuilder, post, postId)
+	if err != nil {
+		return res, err
+	}
+	q, args, err := sb.OrderBy("r.revision").ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetRevisions: %w", err)
+	}
+	err = r.db.Select(&res, q, args...)
+	return res, err
+}
+
+func (r *RevisionRepo) GetRevision(post string, postId, revision int64) (RevisionResponse, error) {
+	res := RevisionResponse{}
+	sb, err := revisionsBuilder(r.sqlbuilder, post, postId)
+	if err != nil {
+		return res, err
+	}
+	q, args, err := sb.Where(squirrel.Eq{"r.revision": revision}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetRevision: %w", err)
+	}
+	err = r.db.QueryRowx(q, args...).StructScan(&res)
+	return res, err
+}
+
+// the content of a post, as it's stored in a revision. title is "" for answers.
+type revisionContent struct {
+	Title string
+	Text  string
+}
+
+// locks the post, and records its current content as revision 1, if it has no revisions yet.
+// posts that were never edited don't have revisions. returns the version of the post, or
+// sql.ErrNoRows if there is no such non-deleted post.
+func ensureBaseRevision(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, post string, postId int64) (int64, error) {
+	table, idColumn, columns, err := revisionTables(post)
+	if err != nil {
+		return 0, err
+	}
+	postTable, authorColumn := "questions", "question_by"
+	if post == "answer" {
+		postTable, authorColumn = "answers", "answer_by"
+	}
+	q, args, err := sqlbuilder.Select("version").From(postTable).
+		Where(squirrel.Eq{idColumn: postId, "deleted_at": nil}).Suffix("FOR UPDATE").ToSql()
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	if err := tx.QueryRowx(q, args...).Scan(&version); err != nil {
+		return 0, err
+	}
+	insertColumns := append([]string{idColumn, "revision", "edited_by", "summary", "created_at"}, columns...)
+	selectColumns := append([]string{idColumn, "1", authorColumn, "''", "created_at"}, columns...)
+	base := sqlbuilder.Select(selectColumns...).From(postTable).
+		Where(squirrel.Eq{idColumn: postId}).
+		Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s WHERE %s = ?)", table, idColumn), postId)
+	q, args, err = sqlbuilder.Insert(table).Columns(insertColumns...).Select(base).ToSql()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(q, args...)
+	return version, err
+}
+
+// records the new content of the post, unless it's the same as the latest revision. call
+// ensureBaseRevision before updating the post.
+func addRevision(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, post string, postId, editedBy int64, content revisionContent, summary string) error {
+	table, idColumn, columns, err := revisionTables(post)
+	if err != nil {
+		return err
+	}
+	q, args, err := sqlbuilder.Select(append([]string{"revision"}, columns...)...).From(table).
+		Where(squirrel.Eq{idColumn: postId}).OrderBy("revision DESC").Limit(1).ToSql()
+	if err != nil {
+		return err
+	}
+	var revision int64
+	latest := revisionContent{}
+	dest := []interface{}{&revision, &latest.Text}
+	if len(columns) == 2 {
+		dest = []interface{}{&revision, &latest.Title, &latest.Text}
+	}
+	if err := tx.QueryRowx(q, args...).Scan(dest...); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if revision > 0 && latest == content {
+		return nil
+	}
+	values := []interface{}{postId, revision + 1, editedBy, summary, content.Text}
+	if len(columns) == 2 {
+		values = []interface{}{postId, revision + 1, editedBy, summary, content.Title, content.Text}
+	}
+	q, args, err = sqlbuilder.Insert(table).
+		Columns(append([]string{idColumn, "revision", "edited_by", "summary"}, columns...)...).
+		Values(values...).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(q, args...)
+	return err
+}
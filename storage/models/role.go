@@ -0,0 +1,22 @@
+package models
+
+// every role can do what the roles before it can do
+const (
+	ROLE_USER = "user"
+	// can edit, delete, and restore any post, and lock questions
+	ROLE_MODERATOR = "moderator"
+	// can change the roles of the users
+	ROLE_ADMIN = "admin"
+)
+
+var roleRanks = map[string]int{ROLE_USER: 1, ROLE_MODERATOR: 2, ROLE_ADMIN: 3}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// whether role is min, or a role above min. unknown roles are below every role.
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[min]
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+type SearchRepository interface {
+	Search(SearchParams) (SearchResponse, error)
+}
+
+type SearchRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewSearchRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *SearchRepo {
+	return &SearchRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+const MAX_SEARCH_QUERY_LENGTH = 200
+
+// ts_headline wraps matches in these, instead of html tags, because it doesn't escape the
+// text around them. see highlight. they are private use characters, removed from the text before
+// ts_headline, so a post can't contain them.
+const (
+	_MATCH_START = "\uE000"
+	_MATCH_STOP  = "\uE001"
+)
+
+// ts_headline options of snippets
+const _HEADLINE_OPTIONS = "StartSel=" + _MATCH_START + ", StopSel=" + _MATCH_STOP + ", MaxWords=35, MinWords=15, MaxFragments=2"
+
+var matchTags = strings.NewReplacer(_MATCH_START, "<mark>", _MATCH_STOP, "</mark>")
+
+// html-escapes the output of ts_headline, and wraps the matches in <mark></mark>
+func highlight(s string) string {
+	return matchTags.Replace(html.EscapeString(s))
+}
+
+// ts_headline of the column, without the match delimiters in the text itself
+func headline(column, options string) string {
+	return fmt.Sprintf("ts_headline('english', translate(%s, '%s%s', ''), query, '%s')", column, _MATCH_START, _MATCH_STOP, options)
+}
+
+type SearchParams struct {
+	// web search syntax: "quoted phrases", -excluded, or
+	Query string
+	// results must be of questions that have all of these tags
+	Tags          []string
+	Limit, Offset uint64
+}
+
+func (sp *SearchParams) Validate() []string {
+	errs := []string{}
+	if len(sp.Query) == 0 {
+		errs = append(errs, "q: missing search query")
+	}
+	if utf8.RuneCountInString(sp.Query) > MAX_SEARCH_QUERY_LENGTH {
+		errs = append(errs, fmt.Sprintf("q: search query can be at most %d characters long", MAX_SEARCH_QUERY_LENGTH))
+	}
+	tags, tagErrs := normalizeTags(sp.Tags)
+	sp.Tags = tags
+	return append(errs, tagErrs...)
+}
+
+type SearchResult struct {
+	BasicUserResponse `json:"author" db:"author"`
+	// "question", or "answer"
+	Type       string `json:"type" db:"type"`
+	QuestionId int64  `json:"question_id" db:"question_id"`
+	// nil for questions
+	AnswerId *int64 `json:"answer_id,omitempty" db:"answer_id"`
+	// title of the question, and a snippet of the text are html. matches are wrapped in
+	// <mark></mark>, and the rest is escaped. answers' titles have no matches.
+	Title     string     `json:"title" db:"title"`
+	Snippet   string     `json:"snippet" db:"snippet"`
+	Rank      float64    `json:"rank" db:"rank"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+}
+
+type SearchResponse struct {
+	Results []SearchResult `json:"results"`
+	HasMore bool           `json:"has_more"`
+}
+
+// search non-deleted questions, and answers. best matches come first.
+func (s *SearchRepo) Search(params SearchParams) (SearchResponse, error) {
+	res := SearchResponse{Results: []SearchResult{}}
+	questions := squirrel.Select("'question' AS type", "q.question_id", "NULL::int AS answer_id",
+		headline("q.title", "HighlightAll=true")+" AS title",
+		headline("q.text", _HEADLINE_OPTIONS)+" AS snippet",
+		"ts_rank(q.search_vector, query) AS rank", "q.created_at",
+		`u.username AS "author.username"`, `u.handle AS "author.handle"`, `u.created_at AS "author.created_at"`, `u.reputation AS "author.reputation"`).
+		From("questions q").
+		JoinClause("CROSS JOIN websearch_to_tsquery('english', ?) query", params.Query).
+		InnerJoin("users u ON u.user_id = q.question_by").
+		Where("q.search_vector @@ query").
+		Where(squirrel.Eq{"q.deleted_at": nil})
+	answers := squirrel.Select("'answer' AS type", "q.question_id", "a.answer_id",
+		fmt.Sprintf("translate(q.title, '%s%s', '') AS title", _MATCH_START, _MATCH_STOP),
+		headline("a.text", _HEADLINE_OPTIONS)+" AS snippet",
+		"ts_rank(a.search_vector, query) AS rank", "a.created_at",
+		`u.username AS "author.username"`, `u.handle AS "author.handle"`, `u.created_at AS "author.created_at"`, `u.reputation AS "author.reputation"`).
+		From("answers a").
+		JoinClause("CROSS JOIN websearch_to_tsquery('english', ?) query", params.Query).
+		InnerJoin("questions q ON q.question_id = a.to_question").
+		InnerJoin("users u ON u.user_id = a.answer_by").
+		Where("a.search_vector @@ query").
+		Where(squirrel.Eq{"a.deleted_at": nil, "q.deleted_at": nil})
+	if len(params.Tags) > 0 {
+		hasTags := squirrel.Select("qt.question_id").From("question_tags qt").
+			InnerJoin("tags t ON t.tag_id = qt.tag_id").
+			Where(squirrel.Eq{"t.tag": params.Tags}).
+			GroupBy("qt.question_id").
+			Having("COUNT(DISTINCT t.tag) = ?", len(params.Tags))
+		tagsSql, tagsArgs, err := hasTags.ToSql()
+		if err != nil {
+			return res, fmt.Errorf("error while building query for Search: %w", err)
+		}
+		questions = questions.Where("q.question_id IN ("+tagsSql+")", tagsArgs...)
+		answers = answers.Where("q.question_id IN ("+tagsSql+")", tagsArgs...)
+	}
+	// squirrel can't build a UNION, so append the second query as a suffix of the first one.
+	// both use '?' placeholders, and the outer query numbers all of them.
+	answersSql, answersArgs, err := answers.ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for Search: %w", err)
+	}
+	union := questions.Suffix("UNION ALL "+answersSql, answersArgs...)
+	q, args, err := s.sqlbuilder.Select("*").FromSelect(union, "results").
+		OrderBy("rank DESC", "created_at DESC").
+		// one more than needed, to know whether there are more results
+		Limit(params.Limit + 1).Offset(params.Offset).
+		ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for Search: %w", err)
+	}
+	rows, err := s.db.Queryx(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sr SearchResult
+		if err := rows.StructScan(&sr); err != nil {
+			return res, err
+		}
+		sr.Title, sr.Snippet = highlight(sr.Title), highlight(sr.Snippet)
+		res.Results = append(res.Results, sr)
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	if uint64(len(res.Results)) > params.Limit {
+		res.Results = res.Results[:params.Limit]
+		res.HasMore = true
+	}
+	return res, nil
+}
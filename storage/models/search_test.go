@@ -0,0 +1,23 @@
+package models
+
+import "testing"
+
+func TestHighlightEscapesEverythingButMatches(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"plain text", "plain text"},
+		{"a " + _MATCH_START + "match" + _MATCH_STOP + " here", "a <mark>match</mark> here"},
+		{
+			"<script>alert(1)</script> " + _MATCH_START + "xss" + _MATCH_STOP,
+			"&lt;script&gt;alert(1)&lt;/script&gt; <mark>xss</mark>",
+		},
+		{`<img src=x onerror="alert('1')">`, "&lt;img src=x onerror=&#34;alert(&#39;1&#39;)&#34;&gt;"},
+		{"</mark><mark>", "&lt;/mark&gt;&lt;mark&gt;"},
+	}
+	for _, tt := range tests {
+		if got := highlight(tt.in); got != tt.out {
+			t.Errorf("highlight(%q): expected %q, got %q", tt.in, tt.out, got)
+		}
+	}
+}
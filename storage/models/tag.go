@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/betelgeuse-7/qa/service/sqlbuild"
+	"github.com/jmoiron/sqlx"
+)
+
+type TagRepository interface {
+	GetTags(uint64, uint64) ([]TagResponse, error)
+	// tag, limit, offset
+	GetQuestionsByTag(string, uint64, uint64) ([]QuestionSummaryResponse, error)
+}
+
+type TagRepo struct {
+	db         *sqlx.DB
+	sqlbuilder squirrel.StatementBuilderType
+}
+
+func NewTagRepo(db *sqlx.DB, sqlbuilder *sqlbuild.Builder) *TagRepo {
+	return &TagRepo{db: db, sqlbuilder: sqlbuilder.B}
+}
+
+const (
+	MAX_TAGS_PER_QUESTION = 5
+	MAX_TAG_LENGTH        = 35
+)
+
+type TagResponse struct {
+	Tag           string `json:"tag" db:"tag"`
+	QuestionCount int64  `json:"question_count" db:"question_count"`
+}
+
+// the most used tags come first
+func (t *TagRepo) GetTags(limit, offset uint64) ([]TagResponse, error) {
+	res := []TagResponse{}
+	q, args, err := t.sqlbuilder.Select("t.tag", "COUNT(q.question_id) AS question_count").
+		From("tags t").
+		LeftJoin("question_tags qt ON qt.tag_id = t.tag_id").
+		LeftJoin("questions q ON q.question_id = qt.question_id AND q.deleted_at IS NULL").
+		GroupBy("t.tag_id", "t.tag").
+		OrderBy("question_count DESC", "t.tag ASC").
+		Limit(limit).Offset(offset).
+		ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetTags: %w", err)
+	}
+	rows, err := t.db.Queryx(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tr TagResponse
+		if err := rows.StructScan(&tr); err != nil {
+			return res, err
+		}
+		res = append(res, tr)
+	}
+	return res, rows.Err()
+}
+
+// returns sql.ErrNoRows if there is no such tag
+func (t *TagRepo) GetQuestionsByTag(tag string, limit, offset uint64) ([]QuestionSummaryResponse, error) {
+	res := []QuestionSummaryResponse{}
+	exists, err := t.tagExists(tag)
+	if err != nil {
+		return res, err
+	}
+	if !exists {
+		return res, sql.ErrNoRows
+	}
+	q, args, err := questionSummaryBuilder(t.sqlbuilder).
+		InnerJoin("question_tags qt ON qt.question_id = q.question_id").
+		InnerJoin("tags t ON t.tag_id = qt.tag_id").
+		Where(squirrel.Eq{"t.tag": tag}).
+		OrderBy("q.created_at DESC", "q.question_id DESC").
+		Limit(limit).Offset(offset).
+		ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetQuestionsByTag: %w", err)
+	}
+	rows, err := t.db.Queryx(q, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var qsr QuestionSummaryResponse
+		if err := rows.StructScan(&qsr); err != nil {
+			return res, err
+		}
+		res = append(res, qsr)
+	}
+	if err := rows.Err(); err != nil {
+		return res, err
+	}
+	return res, fillTagsForQuestions(t.db, t.sqlbuilder, res)
+}
+
+func (t *TagRepo) tagExists(tag string) (bool, error) {
+	q, args, err := t.sqlbuilder.Select("tag_id").From("tags").Where(squirrel.Eq{"tag": tag}).ToSql()
+	if err != nil {
+		return false, err
+	}
+	var tagId int64
+	err = t.db.QueryRowx(q, args...).Scan(&tagId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// set the Tags field of every question summary with one query
+func fillTagsForQuestions(db sqlx.Queryer, sqlbuilder squirrel.StatementBuilderType, questions []QuestionSummaryResponse) error {
+	if len(questions) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(questions))
+	for _, v := range questions {
+		ids = append(ids, v.QuestionId)
+	}
+	q, args, err := sqlbuilder.Select("qt.question_id", "t.tag").From("question_tags qt").
+		InnerJoin("tags t ON t.tag_id = qt.tag_id").
+		Where(squirrel.Eq{"qt.question_id": ids}).
+		OrderBy("t.tag").
+		ToSql()
+	if err != nil {
+		return err
+	}
+	rows, err := db.Queryx(q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	tags := map[int64][]string{}
+	for rows.Next() {
+		var questionId int64
+		var tag string
+		if err := rows.Scan(&questionId, &tag); err != nil {
+			return err
+		}
+		tags[questionId] = append(tags[questionId], tag)
+	}
+	for i := range questions {
+		questions[i].Tags = tags[questions[i].QuestionId]
+		if questions[i].Tags == nil {
+			questions[i].Tags = []string{}
+		}
+	}
+	return rows.Err()
+}
+
+// replace the tags of a question with the given tags. tags that don't exist yet are created.
+func setTagsForQuestion(tx *sqlx.Tx, sqlbuilder squirrel.StatementBuilderType, questionId int64, tags []string) error {
+	q, args, err := sqlbuilder.Delete("question_tags").Where(squirrel.Eq{"question_id": questionId}).ToSql()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(q, args...); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		// DO UPDATE instead of DO NOTHING, so that RETURNING gives back the id of an existing tag too
+		q, args, err := sqlbuilder.Insert("tags").Columns("tag").Values(tag).
+			Suffix("ON CONFLICT (tag) DO UPDATE SET tag = EXCLUDED.tag RETURNING tag_id").ToSql()
+		if err != nil {
+			return err
+		}
+		var tagId int64
+		if err := tx.QueryRowx(q, args...).Scan(&tagId); err != nil {
+			return err
+		}
+		q, args, err = sqlbuilder.Insert("question_tags").Columns("question_id", "tag_id").
+			Values(questionId, tagId).ToSql()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(q, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lowercase, trim, and deduplicate tags. returns the normalized tags, and validation errors.
+//
+// a nil slice stays nil, so that the callers can tell "no tags given" from "remove all tags".
+func normalizeTags(tags []string) ([]string, []string) {
+	if tags == nil {
+		return nil, nil
+	}
+	res := []string{}
+	errs := []string{}
+	seen := map[string]bool{}
+	for _, v := range tags {
+		tag := strings.ToLower(strings.TrimSpace(v))
+		if len(tag) == 0 {
+			errs = append(errs, "tags: empty tag")
+			continue
+		}
+		if len(tag) > MAX_TAG_LENGTH {
+			errs = append(errs, fmt.Sprintf("tags: '%s' is longer than %d characters", tag, MAX_TAG_LENGTH))
+			continue
+		}
+		if !isValidTag(tag) {
+			errs = append(errs, fmt.Sprintf("tags: '%s' contains invalid characters", tag))
+			continue
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		res = append(res, tag)
+	}
+	if len(res) > MAX_TAGS_PER_QUESTION {
+		errs = append(errs, fmt.Sprintf("tags: a question can have at most %d tags", MAX_TAGS_PER_QUESTION))
+	}
+	return res, errs
+}
+
+// letters, digits, and '-', '.', '+', '#' (c++, c#, node.js ...)
+func isValidTag(tag string) bool {
+	for _, r := range tag {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '-' || r == '.' || r == '+' || r == '#':
+		default:
+			return false
+		}
+	}
+	return true
+}
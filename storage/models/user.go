@@ -16,10 +16,22 @@ import (
 
 type UserRepository interface {
 	Register(*UserRegisterPayload) (int64, error)
+	// registers a user with the role
+	RegisterWithRole(*UserRegisterPayload, string) (int64, error)
 	GetUserLoginResults(string) (UserLoginResults, error)
 	DeleteUser(int64) error
 	IsUserDeleted(int64) (bool, error)
 	GetUserProfile(int64, ServerInfo) (UserProfileResponse, error)
+	// returns sql.ErrNoRows for deleted users
+	GetUserRole(int64) (string, error)
+	// userId, role
+	SetUserRole(int64, string) error
+	// returns sql.ErrNoRows for deleted users
+	GetUserStanding(int64) (UserStanding, error)
+	// returns sql.ErrNoRows if there is no deleted user with the email
+	GetDeletedUser(string) (DeletedUser, error)
+	// returns sql.ErrNoRows if the user is not deleted
+	RestoreUser(int64) error
 }
 
 type UserRepo struct {
@@ -54,6 +66,8 @@ func (u *UserRegisterPayload) Validate() ([]string, error) {
 type UserLoginPayload struct {
 	Email    string `db:"email" json:"email"`
 	Password string `db:"password" json:"password"`
+	// return the tokens in the response body, instead of setting cookies
+	ReturnTokens bool `json:"return_tokens"`
 }
 
 func (u *UserLoginPayload) Okay() (okay.ValidationErrors, error) {
@@ -67,6 +81,7 @@ func (u *UserLoginPayload) Okay() (okay.ValidationErrors, error) {
 type UserLoginResults struct {
 	Pwd    string `db:"password"`
 	UserId int64  `db:"user_id"`
+	Role   string `db:"role"`
 }
 
 func (u *UserLoginPayload) Validate() ([]string, error) {
@@ -77,10 +92,19 @@ type BasicUserResponse struct {
 	Username string `json:"username" db:"username"`
 	Handle   string `json:"handle" db:"handle"`
 	// ? why isn't this populated when scanning results from db ? vvv
-	CreatedAt *time.Time `json:"registered_at" db:"created_at"`
+	CreatedAt  *time.Time `json:"registered_at" db:"created_at"`
+	Reputation int64      `json:"reputation" db:"reputation"`
 }
 
 func (u *UserRepo) Register(payload *UserRegisterPayload) (int64, error) {
+	return u.RegisterWithRole(payload, ROLE_USER)
+}
+
+// the role is set by the insert, so a failure can't leave a user with another role behind
+func (u *UserRepo) RegisterWithRole(payload *UserRegisterPayload, role string) (int64, error) {
+	if !IsValidRole(role) {
+		return -1, fmt.Errorf("models.RegisterWithRole: invalid role '%s'", role)
+	}
 	hasher := hashpwd.New(payload.Password)
 	hasher.HashPwd()
 	if err := hasher.Error(); err != nil {
@@ -88,8 +112,8 @@ func (u *UserRepo) Register(payload *UserRegisterPayload) (int64, error) {
 	}
 	payload.Password = hasher.Hashed()
 	q, args, err := u.sqlbuilder.Insert("users").
-		Columns("username", "email", "handle", "password").
-		Values(payload.Username, payload.Email, payload.Handle, payload.Password).
+		Columns("username", "email", "handle", "password", "role").
+		Values(payload.Username, payload.Email, payload.Handle, payload.Password, role).
 		Suffix("RETURNING user_id").
 		ToSql()
 	if err != nil {
@@ -116,7 +140,7 @@ func (u *UserRepo) Register(payload *UserRegisterPayload) (int64, error) {
 // returns bcrypt-hashed password, and an error
 func (u *UserRepo) GetUserLoginResults(email string) (UserLoginResults, error) {
 	ulr := UserLoginResults{}
-	q, args, err := u.sqlbuilder.Select("user_id", "password").From("users").Where(squirrel.Eq{
+	q, args, err := u.sqlbuilder.Select("user_id", "password", "role").From("users").Where(squirrel.Eq{
 		"email":      email,
 		"deleted_at": nil,
 	}).Limit(1).ToSql()
@@ -156,6 +180,101 @@ func (u *UserRepo) DeleteUser(userId int64) error {
 	return nil
 }
 
+type DeletedUser struct {
+	UserId    int64     `db:"user_id"`
+	Pwd       string    `db:"password"`
+	DeletedAt time.Time `db:"deleted_at"`
+}
+
+func (u *UserRepo) GetDeletedUser(email string) (DeletedUser, error) {
+	du := DeletedUser{}
+	q, args, err := u.sqlbuilder.Select("user_id", "password", "deleted_at").From("users").
+		Where(squirrel.Eq{"email": email}).Where(squirrel.NotEq{"deleted_at": nil}).ToSql()
+	if err != nil {
+		return du, fmt.Errorf("error while building query for GetDeletedUser: %w", err)
+	}
+	err = u.db.Get(&du, q, args...)
+	return du, err
+}
+
+func (u *UserRepo) RestoreUser(userId int64) error {
+	q, args, err := u.sqlbuilder.Update("users").Set("deleted_at", nil).
+		Where(squirrel.Eq{"user_id": userId}).
+		Where(squirrel.NotEq{"deleted_at": nil}).
+		Suffix("RETURNING user_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for RestoreUser: %w", err)
+	}
+	return u.db.QueryRowx(q, args...).Scan(&userId)
+}
+
+func (u *UserRepo) GetUserRole(userId int64) (string, error) {
+	q, args, err := u.sqlbuilder.Select("role").From("users").
+		Where(squirrel.Eq{"user_id": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return "", fmt.Errorf("error while building query for GetUserRole: %w", err)
+	}
+	var role string
+	err = u.db.QueryRowx(q, args...).Scan(&role)
+	return role, err
+}
+
+// returns sql.ErrNoRows if there is no such user
+func (u *UserRepo) SetUserRole(userId int64, role string) error {
+	if !IsValidRole(role) {
+		return fmt.Errorf("models.SetUserRole: invalid role '%s'", role)
+	}
+	q, args, err := u.sqlbuilder.Update("users").Set("role", role).
+		Where(squirrel.Eq{"user_id": userId, "deleted_at": nil}).
+		Suffix("RETURNING user_id").ToSql()
+	if err != nil {
+		return fmt.Errorf("error while building query for SetUserRole: %w", err)
+	}
+	return u.db.QueryRowx(q, args...).Scan(&userId)
+}
+
+// what privileges are granted by
+type UserStanding struct {
+	CreatedAt time.Time
+	// non-deleted questions, and answers
+	Posts int64
+	// upvotes minus downvotes received on non-deleted questions, and answers
+	NetUpvotes int64
+}
+
+func (u *UserRepo) GetUserStanding(userId int64) (UserStanding, error) {
+	res := UserStanding{}
+	q, args, err := u.sqlbuilder.Select("created_at").From("users").
+		Where(squirrel.Eq{"user_id": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetUserStanding: %w", err)
+	}
+	if err := u.db.QueryRowx(q, args...).Scan(&res.CreatedAt); err != nil {
+		return res, err
+	}
+	questions, args, err := u.sqlbuilder.Select("COUNT(*)").From("questions").
+		Where(squirrel.Eq{"question_by": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetUserStanding: %w", err)
+	}
+	answers, _, err := u.sqlbuilder.Select("COUNT(*)").From("answers").
+		Where(squirrel.Eq{"answer_by": userId, "deleted_at": nil}).ToSql()
+	if err != nil {
+		return res, fmt.Errorf("error while building query for GetUserStanding: %w", err)
+	}
+	// both subqueries have the user id as $1
+	query := "SELECT (" + questions + ") + (" + answers + ") AS posts;"
+	if err := u.db.QueryRowx(query, args...).Scan(&res.Posts); err != nil {
+		return res, err
+	}
+	ups, downs, err := u.getTotalUpvoteDownvotes(userId)
+	if err != nil {
+		return res, err
+	}
+	res.NetUpvotes = ups - downs
+	return res, nil
+}
+
 type UserLastQuestionResponse struct {
 	Id        int64      `db:"question_id" json:"-"`
 	Title     string     `db:"title" json:"title"`
@@ -175,10 +294,12 @@ type UserProfileResponse struct {
 	Username       string                     `db:"username" json:"username"`
 	Handle         string                     `db:"handle" json:"handle"`
 	CreatedAt      *time.Time                 `db:"created_at" json:"registered_at"`
+	Reputation     int64                      `db:"reputation" json:"reputation"`
 	TotalUpvotes   int64                      `json:"total_upvotes"`
 	TotalDownvotes int64                      `json:"total_downvotes"`
 	LastQuestions  []UserLastQuestionResponse `json:"last_questions"`
 	LastAnswers    []UserLastAnswerResponse   `json:"last_answers"`
+	Badges         []UserBadgeResponse        `json:"badges"`
 }
 
 type ServerInfo struct {
@@ -193,7 +314,7 @@ func (u *UserRepo) GetUserProfile(userId int64, serverInfo ServerInfo) (UserProf
 	if userId > int64(postgres.MAX_INT_VAL) {
 		return res, errors.New(POSTGRES_INVALID_ID)
 	}
-	q, args, err := u.sqlbuilder.Select("username", "handle", "created_at").From("users").
+	q, args, err := u.sqlbuilder.Select("username", "handle", "created_at", "reputation").From("users").
 		Where(squirrel.Eq{"deleted_at": nil, "user_id": userId}).ToSql()
 	if err != nil {
 		return res, err
@@ -214,32 +335,45 @@ func (u *UserRepo) GetUserProfile(userId int64, serverInfo ServerInfo) (UserProf
 	}
 	res.LastAnswers = lastAnswers
 	res.LastQuestions = lastQuestions
-	downs, ups, err := u.getTotalUpvoteDownvotes(userId)
+	ups, downs, err := u.getTotalUpvoteDownvotes(userId)
 	if err != nil {
 		return res, err
 	}
 	res.TotalDownvotes = downs
 	res.TotalUpvotes = ups
+	badges, err := getUserBadges(u.db, u.sqlbuilder, userId)
+	if err != nil {
+		return res, err
+	}
+	res.Badges = badges
 	return res, nil
 }
 
-// upvotes, downvotes, error
+// upvotes, and downvotes the user received on their non-deleted questions, and answers
 func (u *UserRepo) getTotalUpvoteDownvotes(userId int64) (int64, int64, error) {
-	q, args, err := u.sqlbuilder.Select("COUNT(qu.question_id)").From("question_upvotes qu").
-		Where(squirrel.Eq{
-			"qu.upvote_by": userId,
-		}).ToSql()
+	received := func(votesTable, postTable, idColumn, authorColumn string) (string, []interface{}, error) {
+		return u.sqlbuilder.Select("COUNT(*)").From(votesTable + " v").
+			InnerJoin(fmt.Sprintf("%s p ON p.%s = v.%s", postTable, idColumn, idColumn)).
+			Where(squirrel.Eq{"p." + authorColumn: userId, "p.deleted_at": nil}).ToSql()
+	}
+	qu, args, err := received("question_upvotes", "questions", "question_id", "question_by")
+	if err != nil {
+		return -1, -1, err
+	}
+	au, _, err := received("answer_upvotes", "answers", "answer_id", "answer_by")
+	if err != nil {
+		return -1, -1, err
+	}
+	qd, _, err := received("question_downvotes", "questions", "question_id", "question_by")
 	if err != nil {
 		return -1, -1, err
 	}
-	q2, _, err := u.sqlbuilder.Select("COUNT(qd.question_id)").From("question_downvotes qd").
-		Where(squirrel.Eq{
-			"qd.downvote_by": userId,
-		}).ToSql()
+	ad, _, err := received("answer_downvotes", "answers", "answer_id", "answer_by")
 	if err != nil {
 		return -1, -1, err
 	}
-	query := "SELECT " + "(" + q + ") AS upvotes, (" + q2 + ") AS downvotes;"
+	// every subquery has the user id as $1
+	query := "SELECT (" + qu + ") + (" + au + ") AS upvotes, (" + qd + ") + (" + ad + ") AS downvotes;"
 	var up, down int64
 	row := u.db.QueryRowx(query, args...)
 	err = row.Scan(&up, &down)
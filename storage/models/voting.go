@@ -1,25 +1,181 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
 
-// type_ is either "downvote", or "upvote"
-func voteQuestion(qr *QuestionRepo, type_ string, questionId, voteBy int64) error {
-	table := ""
-	columns := []string{}
+	"github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+)
+
+// the vote of a user on a question, or an answer
+const (
+	VOTE_NONE     = "none"
+	VOTE_UPVOTE   = "upvote"
+	VOTE_DOWNVOTE = "downvote"
+)
+
+// post is either "question", or "answer"
+//
+// return: upvotes table, downvotes table, id column
+func voteTables(post string) (string, string, string, error) {
+	switch post {
+	case "question":
+		return "question_upvotes", "question_downvotes", "question_id", nil
+	case "answer":
+		return "answer_upvotes", "answer_downvotes", "answer_id", nil
+	}
+	return "", "", "", fmt.Errorf("models.voteTables: invalid post type '%s'", post)
+}
+
+// type_ is either "downvote", or "upvote". an existing vote of the opposite type is removed in
+// the same transaction, and voting the same way again is a no-op. so, a user has at most one vote
+// on a post.
+//
+// the reputation of the author of the post changes in the same transaction.
+//
+// returns the current vote of the user.
+func castVote(db *sqlx.DB, sqlbuilder squirrel.StatementBuilderType, post, type_ string, postId, voteBy int64) (string, error) {
+	upTable, downTable, idColumn, err := voteTables(post)
+	if err != nil {
+		return "", err
+	}
+	table, oppositeTable, column, oppositeColumn, oppositeType := "", "", "", "", ""
 	switch type_ {
-	case "downvote":
-		table = "question_downvotes"
-		columns = append(columns, "question_id", "downvote_by")
-	case "upvote":
-		table = "question_upvotes"
-		columns = append(columns, "question_id", "upvote_by")
+	case VOTE_DOWNVOTE:
+		table, oppositeTable = downTable, upTable
+		column, oppositeColumn = "downvote_by", "upvote_by"
+		oppositeType = VOTE_UPVOTE
+	case VOTE_UPVOTE:
+		table, oppositeTable = upTable, downTable
+		column, oppositeColumn = "upvote_by", "downvote_by"
+		oppositeType = VOTE_DOWNVOTE
 	default:
-		return fmt.Errorf("models.voteQuestion: invalid vote type '%s'", type_)
+		return "", fmt.Errorf("models.castVote: invalid vote type '%s'", type_)
 	}
-	q, args, err := qr.sqlbuilder.Insert(table).Columns(columns...).Values(questionId, voteBy).ToSql()
+	reason, err := voteReputationReason(post, type_)
 	if err != nil {
-		return err
+		return "", err
+	}
+	oppositeReason, err := voteReputationReason(post, oppositeType)
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	if err := lockVote(tx, postId, voteBy); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	q, args, err := sqlbuilder.Delete(oppositeTable).
+		Where(squirrel.Eq{idColumn: postId, oppositeColumn: voteBy}).ToSql()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	result, err := tx.Exec(q, args...)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	if deleted > 0 {
+		if err := revertReputationEvent(tx, sqlbuilder, voteBy, oppositeReason, post, postId); err != nil {
+			tx.Rollback()
+			return "", err
+		}
 	}
-	_, err = qr.db.Exec(q, args...)
+	q, args, err = sqlbuilder.Insert(table).Columns(idColumn, column).Values(postId, voteBy).
+		Suffix("ON CONFLICT DO NOTHING").ToSql()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	result, err = tx.Exec(q, args...)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	// voting the same way again doesn't change the reputation again
+	if inserted > 0 {
+		authorId, err := postAuthor(tx, sqlbuilder, post, postId)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		if err := addReputationEvent(tx, sqlbuilder, authorId, voteBy, reason, post, postId); err != nil {
+			tx.Rollback()
+			return "", err
+		}
+	}
+	return type_, tx.Commit()
+}
+
+// remove the vote of the user, if there is one, and undo its reputation change. returns the
+// current vote of the user, which is VOTE_NONE.
+func retractVote(db *sqlx.DB, sqlbuilder squirrel.StatementBuilderType, post string, postId, voteBy int64) (string, error) {
+	upTable, downTable, idColumn, err := voteTables(post)
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	if err := lockVote(tx, postId, voteBy); err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	deletes := map[string]squirrel.DeleteBuilder{
+		VOTE_UPVOTE:   sqlbuilder.Delete(upTable).Where(squirrel.Eq{idColumn: postId, "upvote_by": voteBy}),
+		VOTE_DOWNVOTE: sqlbuilder.Delete(downTable).Where(squirrel.Eq{idColumn: postId, "downvote_by": voteBy}),
+	}
+	for type_, d := range deletes {
+		q, args, err := d.ToSql()
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		result, err := tx.Exec(q, args...)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		if deleted == 0 {
+			continue
+		}
+		reason, err := voteReputationReason(post, type_)
+		if err != nil {
+			tx.Rollback()
+			return "", err
+		}
+		if err := revertReputationEvent(tx, sqlbuilder, voteBy, reason, post, postId); err != nil {
+			tx.Rollback()
+			return "", err
+		}
+	}
+	return VOTE_NONE, tx.Commit()
+}
+
+// serialize the votes of a user on a post, so that two concurrent requests can't
+// leave the user in both the upvotes, and the downvotes tables.
+// the lock is released when the transaction ends.
+func lockVote(tx *sqlx.Tx, postId, voteBy int64) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1::int, $2::int)", postId, voteBy)
 	return err
 }
@@ -3,7 +3,6 @@ package postgres
 import (
 	"errors"
 	"fmt"
-	"os"
 
 	"github.com/betelgeuse-7/qa/config"
 	"github.com/jmoiron/sqlx"
@@ -18,19 +17,15 @@ const (
 )
 
 type Postgres struct {
-	cfg      *config.ConfigRelationalDB
-	password string
-	Db       *sqlx.DB
+	cfg *config.ConfigRelationalDB
+	Db  *sqlx.DB
 }
 
 func New(cfg *config.ConfigRelationalDB) (*Postgres, error) {
-	p := &Postgres{cfg: cfg}
-	pwd, err := getPostgresPwdFromEnv()
-	if err != nil {
-		return nil, err
+	if len(cfg.Password) == 0 {
+		return nil, errors.New("postgres.New: no password")
 	}
-	p.password = pwd
-	return p, nil
+	return &Postgres{cfg: cfg}, nil
 }
 
 func (p *Postgres) Connect() error {
@@ -44,14 +39,6 @@ func (p *Postgres) Connect() error {
 }
 
 func (p *Postgres) makeConnStr() string {
-	str := fmt.Sprintf("dbname=%s host=%s user=%s port=%d sslmode=%s password=%s", p.cfg.DbName, p.cfg.Host, p.cfg.User, p.cfg.Port, p.cfg.Ssl, p.password)
+	str := fmt.Sprintf("dbname=%s host=%s user=%s port=%d sslmode=%s password=%s", p.cfg.DbName, p.cfg.Host, p.cfg.User, p.cfg.Port, p.cfg.Ssl, p.cfg.Password)
 	return str
 }
-
-func getPostgresPwdFromEnv() (string, error) {
-	pwd := os.Getenv("POSTGRES_PWD")
-	if len(pwd) == 0 {
-		return "", errors.New("environment variable 'POSTGRES_PWD' is not set")
-	}
-	return pwd, nil
-}